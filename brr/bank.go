@@ -0,0 +1,173 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Returned when a bank doesn't fit in ARAM or the base address isn't usable.
+var ErrInvalidBank = errors.New("invalid bank")
+
+// Size of the SPC700 address space.
+const kAramSize = 0x10000
+
+// Size of a sample directory entry: 16-bit start and loop addresses.
+const kDirEntrySize = 4
+
+// Number of entries that the DSP can address in the sample directory.
+const kDirEntries = 256
+
+// A sample to pack into a bank.
+type BankSample struct {
+	// Name used for the sample's symbols.
+	Name string
+
+	BrrData []byte
+
+	// Byte offset of the loop block within BrrData, or -1 if there is no loop.
+	LoopOffset int
+}
+
+// Where a sample ended up in a bank.
+type BankEntry struct {
+	Name string
+
+	// Sample number, which is the index into the sample directory (SRCN).
+	Index int
+
+	// ARAM address of the first block.
+	Start int
+
+	// ARAM address of the loop block. Samples without a loop point at their start.
+	Loop int
+
+	// Size of the BRR data in bytes.
+	Size int
+}
+
+// Samples laid out in ARAM after a sample directory.
+type Bank struct {
+	// ARAM address of the sample directory. The DIR register is Base >> 8.
+	Base int
+
+	// The sample directory followed by the BRR data of each sample, to be loaded at Base.
+	Data []byte
+
+	Entries []BankEntry
+}
+
+// Lays out the samples in ARAM starting at base. The sample directory comes first, with a
+// 4-byte entry for each sample: the start address and the loop address, little-endian.
+// The BRR data of each sample follows, in order. base must be a multiple of 0x100, since
+// the DSP's DIR register selects a page. There can be up to 256 samples, the most that
+// the DSP can address, and their names must still be unique after being made into
+// symbols.
+func PackBank(samples []BankSample, base int) (*Bank, error) {
+	if base < 0 || base%0x100 != 0 {
+		return nil, fmt.Errorf("%w: base 0x%X must be a multiple of 0x100", ErrInvalidBank,
+			base)
+	}
+	if len(samples) > kDirEntries {
+		return nil, fmt.Errorf("%w: %d samples, the directory holds %d", ErrInvalidBank,
+			len(samples), kDirEntries)
+	}
+
+	symbols := map[string]string{}
+	for _, sample := range samples {
+		symbol := symbolName(sample.Name)
+		if other, found := symbols[symbol]; found {
+			return nil, fmt.Errorf("%w: %q and %q are both named %s", ErrInvalidBank, other,
+				sample.Name, symbol)
+		}
+		symbols[symbol] = sample.Name
+	}
+
+	bank := &Bank{Base: base}
+	dirSize := len(samples) * kDirEntrySize
+	bank.Data = make([]byte, dirSize)
+	address := base + dirSize
+
+	for i, sample := range samples {
+		if len(sample.BrrData)%9 != 0 {
+			return nil, fmt.Errorf("%w: %s: %w", ErrInvalidBank, sample.Name, ErrInvalidBrr)
+		}
+
+		entry := BankEntry{
+			Name:  sample.Name,
+			Index: i,
+			Start: address,
+			Loop:  address,
+			Size:  len(sample.BrrData),
+		}
+		if sample.LoopOffset >= 0 {
+			if sample.LoopOffset%9 != 0 || sample.LoopOffset >= len(sample.BrrData) {
+				return nil, fmt.Errorf("%w: %s: loop offset %d", ErrInvalidBank, sample.Name,
+					sample.LoopOffset)
+			}
+			entry.Loop = address + sample.LoopOffset
+		}
+
+		bank.Data[i*4] = byte(entry.Start)
+		bank.Data[i*4+1] = byte(entry.Start >> 8)
+		bank.Data[i*4+2] = byte(entry.Loop)
+		bank.Data[i*4+3] = byte(entry.Loop >> 8)
+
+		bank.Data = append(bank.Data, sample.BrrData...)
+		bank.Entries = append(bank.Entries, entry)
+		address += len(sample.BrrData)
+	}
+
+	if address > kAramSize {
+		return nil, fmt.Errorf("%w: ends at 0x%X, past the end of ARAM", ErrInvalidBank,
+			address)
+	}
+
+	return bank, nil
+}
+
+// Returns the name with characters that can't be used in a symbol replaced.
+func symbolName(name string) string {
+	symbol := []rune{}
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_':
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				symbol = append(symbol, '_')
+			}
+		default:
+			r = '_'
+		}
+		symbol = append(symbol, r)
+	}
+	return string(symbol)
+}
+
+// Writes a listing of the bank's symbols as assembler equates: the directory address,
+// and the index, addresses and size of each sample.
+func (b *Bank) WriteSymbols(w io.Writer) error {
+	lines := []string{
+		fmt.Sprintf("DIR = $%04X", b.Base),
+		fmt.Sprintf("DIR_PAGE = $%02X", b.Base>>8),
+		fmt.Sprintf("SAMPLE_COUNT = %d", len(b.Entries)),
+	}
+
+	for _, e := range b.Entries {
+		name := symbolName(e.Name)
+		lines = append(lines, "",
+			fmt.Sprintf("%s_INDEX = %d", name, e.Index),
+			fmt.Sprintf("%s_START = $%04X", name, e.Start),
+			fmt.Sprintf("%s_LOOP = $%04X", name, e.Loop),
+			fmt.Sprintf("%s_SIZE = %d", name, e.Size),
+		)
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
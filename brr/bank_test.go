@@ -0,0 +1,69 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackBank(t *testing.T) {
+	samples := []BankSample{
+		{Name: "kick drum", BrrData: make([]byte, 18), LoopOffset: -1},
+		{Name: "2nd", BrrData: make([]byte, 27), LoopOffset: 9},
+	}
+
+	bank, err := PackBank(samples, 0x300)
+	assert.NoError(t, err)
+	assert.Len(t, bank.Data, 8+18+27)
+
+	// The directory entries point past the directory itself.
+	assert.Equal(t, []byte{0x08, 0x03, 0x08, 0x03, 0x1A, 0x03, 0x23, 0x03}, bank.Data[:8])
+	assert.Equal(t, BankEntry{Name: "2nd", Index: 1, Start: 0x31A, Loop: 0x323, Size: 27},
+		bank.Entries[1])
+
+	var symbols bytes.Buffer
+	assert.NoError(t, bank.WriteSymbols(&symbols))
+	assert.Contains(t, symbols.String(), "DIR = $0300\n")
+	assert.Contains(t, symbols.String(), "kick_drum_START = $0308\n")
+	assert.Contains(t, symbols.String(), "_2nd_LOOP = $0323\n")
+
+	_, err = PackBank(samples, 0x310)
+	assert.ErrorIs(t, err, ErrInvalidBank)
+
+	_, err = PackBank(samples, 0x10000)
+	assert.ErrorIs(t, err, ErrInvalidBank)
+
+	samples[1].LoopOffset = 27
+	_, err = PackBank(samples, 0x300)
+	assert.ErrorIs(t, err, ErrInvalidBank)
+	samples[1].LoopOffset = 9
+
+	// Names that are the same as symbols would define the same symbols twice.
+	samples = append(samples, BankSample{Name: "kick_drum", BrrData: make([]byte, 9),
+		LoopOffset: -1})
+	_, err = PackBank(samples, 0x300)
+	assert.ErrorIs(t, err, ErrInvalidBank)
+	assert.ErrorContains(t, err, "kick_drum")
+}
+
+func TestPackBankLimit(t *testing.T) {
+	// The DSP can only address 256 samples.
+	samples := []BankSample{}
+	for i := 0; i < 257; i++ {
+		samples = append(samples, BankSample{Name: fmt.Sprint("s", i),
+			BrrData: make([]byte, 9), LoopOffset: -1})
+	}
+
+	_, err := PackBank(samples, 0x200)
+	assert.ErrorIs(t, err, ErrInvalidBank)
+
+	bank, err := PackBank(samples[:256], 0x200)
+	assert.NoError(t, err)
+	assert.Len(t, bank.Entries, 256)
+}
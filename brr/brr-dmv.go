@@ -87,9 +87,9 @@ func (c *dmvCodec) getLoopOpt() int {
 	}
 }
 
-func (c *dmvCodec) Encode(pcmData []int16) []byte {
+func (c *dmvCodec) Encode(pcmData []int16) ([]byte, EncodeResult) {
 	output := []byte{}
-	c.stats = EncodingStats{}
+	result := EncodeResult{OriginalLength: len(pcmData)}
 
 	compat := c.compat
 	loopPoint := c.getLoopOpt()
@@ -131,12 +131,17 @@ func (c *dmvCodec) Encode(pcmData []int16) []byte {
 			// 16-sample align loop_start
 			loopPoint += start_align
 		}
+
+		result.UnrolledSamples = len(pcmData) - result.OriginalLength
 	} else {
 		for (len(pcmData) & 15) != 0 {
 			pcmData = append(pcmData, 0)
 		}
 	}
 
+	// The overflow adjustment below modifies the input, so keep the original for measuring.
+	originalPcm := append([]int16{}, pcmData...)
+
 	const base_adjust_rate float64 = 0.0004
 	var adjust_rate float64 = base_adjust_rate
 	var loop_block int = loopPoint / 16 // -1 (disabled) will also result in the first block
@@ -147,12 +152,6 @@ func (c *dmvCodec) Encode(pcmData []int16) []byte {
 	//best_samp[0] = 0; already inited
 	//best_samp[1] = 0;
 
-	//totalBlocks := wimax
-	totalError := float64(0)
-	avgError := float64(0)
-	minError := float64(0)
-	maxError := float64(0)
-
 	for wi != wimax {
 		var p = pcmData[wi*16:]
 		var best_err float64 = 1e20
@@ -336,26 +335,12 @@ func (c *dmvCodec) Encode(pcmData []int16) []byte {
 			best_samp[0] = best_samp[16]
 			best_samp[1] = best_samp[17]
 
-			totalError += best_err
-
-			if best_err < minError {
-				minError = best_err
-			}
-			if best_err > maxError {
-				maxError = best_err
-			}
 			output = append(output, best_data[:]...)
 
 			wi += 1
 		}
 	}
 
-	if wimax == 0 {
-		minError = 0
-	} else {
-		avgError = totalError / float64(wimax)
-	}
-
 	// Original BrrCodec adds an additional block if the loop is not enabled (which doesn't make sense to me.)
 	if len(output) == 0 || (compat && loopPoint < 0) {
 		output = append(output, 0, 0, 0, 0, 0, 0, 0, 0, 0)
@@ -367,6 +352,14 @@ func (c *dmvCodec) Encode(pcmData []int16) []byte {
 	}
 	output[len(output)-9] |= last_header_set_bits
 
+	result.LoopBlock = -1
+	result.LoopOffset = -1
+	if loopPoint >= 0 {
+		result.LoopBlock = loop_block
+		result.LoopOffset = loop_block * 9
+	}
+	result.PaddedLength = len(output) / 9 * 16
+
 	// if !bc.userPitchEnabled {
 	// 	// 0.128 = 0x1000 / 32000
 	// 	x := int(float64(bc.inputSampleRate)*0.128 + 0.5)
@@ -380,14 +373,9 @@ func (c *dmvCodec) Encode(pcmData []int16) []byte {
 	// 	bc.pitchStepBase = x
 	// }
 
-	c.stats = EncodingStats{
-		AvgError:   avgError,
-		MinError:   minError,
-		MaxError:   maxError,
-		TotalError: totalError,
-	}
+	c.stats = measureEncoding(originalPcm, output)
 
-	return output
+	return output, result
 }
 
 func (c *dmvCodec) Decode(brrData []byte) ([]int16, SampleRate) {
@@ -581,7 +569,32 @@ func testOverflow(ls []int16) uint8 {
 }
 
 func (c *dmvCodec) EncodingStats() EncodingStats {
-	return EncodingStats{}
+	return c.stats
+}
+
+// Returns the number of samples that Encode produces for the given input length and loop
+// start, after alignment and unrolling. This follows the same steps as Encode.
+func (c *dmvCodec) EncodedLength(length int, loopStart int) int {
+	if loopStart >= length {
+		loopStart = -1
+	}
+
+	if loopStart >= 0 {
+		startAlign := (16 - (loopStart & 15)) & 15
+		loopSize := length - loopStart
+		endAlign := loopSize
+		for endAlign&15 != 0 {
+			endAlign <<= 1
+		}
+		length += endAlign - loopSize + startAlign
+	}
+
+	length = (length + 15) &^ 15
+	if length == 0 || (c.compat && loopStart < 0) {
+		// Same as the extra block that Encode adds.
+		length += 16
+	}
+	return length
 }
 
 // Clamp an integer value to the given number of bits. e.g., clamp(x, 8) clamps to
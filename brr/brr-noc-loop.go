@@ -0,0 +1,152 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"bytes"
+	"math"
+)
+
+// Number of times the loop block and the rest of the loop are re-encoded while waiting
+// for the history at the end of the loop to settle.
+const kLoopFitPasses = 4
+
+// Returns the total absolute error of a block decoded from the given history, in 15-bit
+// units, and the history after it. Fails if a sample leaves the range that the encoder keeps
+// to, or with the gaussian guard, if it overflows the gaussian interpolation, since the
+// block isn't safe to play from that history.
+func (c *nocCodec) blockErrorFrom(block []byte, pcmData []int16, h brrHistory) (int,
+	brrHistory, bool) {
+
+	samples, prev1, prev2, overflow := decodeBlockHw(block, h.prev1, h.prev2)
+	if overflow != 0 {
+		return 0, h, false
+	}
+
+	err := 0
+	older, old := h.prev2, h.prev1
+	for i, s := range samples {
+		if s < -0x3FFA || s > 0x3FF8 {
+			return 0, h, false
+		}
+		if c.gaussGuard && gaussOverflows(older, old, s) {
+			return 0, h, false
+		}
+		older, old = old, s
+		e := int(pcmData[i])>>1 - s
+		if e < 0 {
+			e = -e
+		}
+		err += e
+	}
+
+	return err, brrHistory{prev1, prev2}, true
+}
+
+// Encode the loop block so that it works from both the history it's first reached with
+// and the histories it's re-entered with. Candidates are quantized against each history,
+// and the one with the lowest error summed over all of them is chosen. Filter 0 ignores
+// the history, so a filtered block is only chosen when it does better than that.
+// Returns false if no candidate is safe.
+func (c *nocCodec) encodeLoopBlock(pcmData []int16, entry brrHistory,
+	reentry []brrHistory) (blockCandidate, bool) {
+
+	histories := append([]brrHistory{entry}, reentry...)
+	candidates := []blockCandidate{}
+	for _, h := range histories {
+		candidates = append(candidates, c.blockCandidates(pcmData, h.prev1, h.prev2, false)...)
+	}
+
+	var best blockCandidate
+	bestTotal := math.MaxInt
+	for _, cand := range candidates {
+		total := 0
+		var first blockCandidate
+		safe := true
+		for i, h := range histories {
+			err, after, ok := c.blockErrorFrom(cand.data, pcmData, h)
+			if !ok {
+				safe = false
+				break
+			}
+			total += err
+			if i == 0 {
+				first = blockCandidate{data: cand.data, err: err, roundErr: err,
+					prev1: after.prev1, prev2: after.prev2}
+			}
+		}
+
+		if safe && total < bestTotal {
+			bestTotal = total
+			best = first
+		}
+	}
+
+	return best, bestTotal != math.MaxInt
+}
+
+// Returns true if every pass through the loop is safe to play: the history at the end of
+// the loop settles, and decoding the whole loop from the first entry and from each
+// re-entry stays in the range that the encoder keeps to (see blockErrorFrom).
+func (c *nocCodec) loopSafe(pcmData []int16, loopPoint int, output []byte) bool {
+	loopOffset := loopPoint / 16 * 9
+	entry, reentry := loopHistories(output, loopOffset)
+
+	settled := reentry[len(reentry)-1]
+	if _, next := decodeFrom(output, loopOffset, settled); next != settled {
+		return false
+	}
+
+	for _, h := range append([]brrHistory{entry}, reentry...) {
+		for readPos := loopPoint; readPos < len(pcmData); readPos += 16 {
+			var ok bool
+			offset := readPos / 16 * 9
+			_, h, ok = c.blockErrorFrom(output[offset:offset+9], pcmData[readPos:readPos+16], h)
+			if !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Re-encodes the loop of the BRR output, allowing filters on the loop block where they
+// work for every pass through the loop. The blocks after the loop block are re-encoded
+// to follow it, which changes the history at the end of the loop, so this repeats until
+// the loop block stops changing. The loop block is only checked against the histories
+// of the previous pass, so the whole loop is checked again at the end, and if any pass
+// isn't safe, the original loop with filter 0 on the loop block is restored. gains holds
+// the search gain of each block and is updated to match.
+func (c *nocCodec) fitLoop(pcmData []int16, loopPoint int, output []byte, gains []int) {
+	loopOffset := loopPoint / 16 * 9
+	original := bytes.Clone(output[loopOffset:])
+	originalGains := append([]int{}, gains[loopPoint/16:]...)
+
+	for pass := 0; pass < kLoopFitPasses; pass++ {
+		entry, reentry := loopHistories(output, loopOffset)
+		block, ok := c.encodeLoopBlock(pcmData[loopPoint:loopPoint+16], entry, reentry)
+		if !ok || bytes.Equal(block.data, output[loopOffset:loopOffset+9]) {
+			break
+		}
+		copy(output[loopOffset:], block.data)
+		gains[loopPoint/16] = block.roundErr - block.err
+
+		prev1 := block.prev1
+		prev2 := block.prev2
+		for readPos := loopPoint + 16; readPos < len(pcmData); readPos += 16 {
+			next := c.encodeBlock(pcmData, readPos, loopPoint, prev1, prev2)
+			prev1 = next.prev1
+			prev2 = next.prev2
+			copy(output[readPos/16*9:], next.data)
+			gains[readPos/16] = next.roundErr - next.err
+		}
+	}
+
+	if !c.loopSafe(pcmData, loopPoint, output) {
+		copy(output[loopOffset:], original)
+		copy(gains[loopPoint/16:], originalGains)
+	}
+}
@@ -0,0 +1,84 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoopMismatch(t *testing.T) {
+	brrData := []byte{
+		0x70, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77,
+		0x74, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x73, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	// The filtered loop block continues from 448 the first time, and from 0 after that.
+	entry, reentry := loopHistories(brrData, 9)
+	assert.Equal(t, brrHistory{448, 448}, entry)
+	assert.Equal(t, []brrHistory{{0, 0}}, reentry)
+	assert.Greater(t, loopMismatch(brrData, 9), 0)
+
+	// Filter 0 doesn't depend on the history.
+	brrData[9] = 0x70
+	assert.Zero(t, loopMismatch(brrData, 9))
+	assert.Zero(t, loopMismatch(brrData, -1))
+}
+
+func TestNocLoopFit(t *testing.T) {
+	encode := func(loopFit string) (EncodeResult, EncodingStats, byte) {
+		codec := NewCodec()
+		codec.PcmData = createPeriodicPcm16(3200, 400)
+		codec.SetLoop(1600)
+		assert.NoError(t, codec.SetCodecOption("loopfit", loopFit))
+		result := codec.Encode()
+		return result, codec.EncodingStats(), codec.BrrData[result.LoopOffset]
+	}
+
+	result, stats, header := encode("0")
+	assert.Zero(t, header&0x0C)
+	assert.Zero(t, result.LoopMismatch)
+
+	// The loop is periodic, so the loop block is entered with the same history each time
+	// and a filter can be used.
+	fitResult, fitStats, fitHeader := encode("1")
+	assert.NotZero(t, fitHeader&0x0C)
+	assert.Zero(t, fitResult.LoopMismatch)
+	assert.Less(t, fitStats.TotalError, stats.TotalError)
+
+	codec := NewCodec()
+	assert.ErrorIs(t, codec.SetCodecOption("loopfit", "2"), ErrInvalidCodecOptionValue)
+}
+
+func TestNocLoopFitSafe(t *testing.T) {
+	// Clipped sines sit near full scale, where filtered loop blocks are most likely to
+	// wrap around on a later pass.
+	random := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 100; trial++ {
+		period := 20 + random.Float64()*200
+		amplitude := 30000 + random.Float64()*40000
+		pcm := make([]int16, 1000+random.Intn(2000))
+		for i := range pcm {
+			s := amplitude * math.Sin(2*math.Pi*float64(i)/period)
+			pcm[i] = int16(math.Max(-32768, math.Min(32767, s)))
+		}
+
+		codec := NewCodec()
+		codec.PcmData = pcm
+		codec.SetLoop(random.Intn(len(pcm) - 100))
+		assert.NoError(t, codec.SetCodecOption("loopfit", "1"))
+		codec.Encode()
+
+		diagnostics, err := ValidateBrr(codec.BrrData, codec.LoopOffset)
+		assert.NoError(t, err)
+		for _, d := range diagnostics {
+			assert.False(t, d.Looped, "trial %d: %s", trial, d)
+		}
+	}
+}
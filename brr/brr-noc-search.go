@@ -0,0 +1,127 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import "sort"
+
+// Number of partial paths kept at each sample by the beam search.
+const kBeamWidth = 16
+
+// Number of partial paths kept at each sample by the exhaustive search, which tries every
+// value but isn't exhaustive past this cap. Paths that reach the same decoder state are
+// merged before the cap is applied.
+const kExhaustiveWidth = 256
+
+// A partially quantized block during the nibble search.
+type nibblePath struct {
+	nibbles [16]int
+	err     int
+	prev1   int
+	prev2   int
+}
+
+// Search for 4-bit samples with a lower total error for the block, for the given filter
+// and shift. The "beam" search tries the rounded value and its two neighbors for each
+// sample. The "exhaustive" search tries all 16 values. Paths that end up with the same
+// last two decoded samples behave identically from then on, so only the best of them is
+// kept, and then only the best paths up to the search's width are followed, so neither
+// result is guaranteed to be optimal. Paths with more error than bound are dropped,
+// since the rounded quantization already does better.
+func (c *nocCodec) searchNibbles(pcmData []int16, prev1 int, prev2 int, filter int,
+	shift int, bound int) (quantizedBlock, bool) {
+
+	width := kBeamWidth
+	if c.search == "exhaustive" {
+		width = kExhaustiveWidth
+	}
+
+	half := 1 << shift >> 1
+	paths := []nibblePath{{prev1: prev1, prev2: prev2}}
+
+	for p := 0; p < 16; p++ {
+		desiredSample := int(pcmData[p]) >> 1
+		next := []nibblePath{}
+
+		for _, path := range paths {
+			base := filterBase(path.prev1, path.prev2, filter)
+
+			low, high := -8, 7
+			if c.search == "beam" {
+				rounded := clamp((desiredSample-base+half)>>shift, 4)
+				if rounded-1 > low {
+					low = rounded - 1
+				}
+				if rounded+1 < high {
+					high = rounded + 1
+				}
+			}
+
+			for brrSample := low; brrSample <= high; brrSample++ {
+				decoded := base + (brrSample << shift)
+				if decoded < -0x3FFA || decoded > 0x3FF8 {
+					continue
+				}
+				if c.gaussGuard && gaussOverflows(path.prev2, path.prev1, decoded) {
+					continue
+				}
+
+				errAmount := desiredSample - decoded
+				if errAmount < 0 {
+					errAmount = -errAmount
+				}
+				if path.err+errAmount > bound {
+					continue
+				}
+
+				extended := path
+				extended.nibbles[p] = brrSample
+				extended.err += errAmount
+				extended.prev2 = path.prev1
+				extended.prev1 = decoded
+
+				next = append(next, extended)
+			}
+		}
+
+		if len(next) == 0 {
+			return quantizedBlock{}, false
+		}
+
+		// Sort by state and then error so that merging only needs to keep the first
+		// path of each state.
+		sort.Slice(next, func(i, j int) bool {
+			if next[i].prev1 != next[j].prev1 {
+				return next[i].prev1 < next[j].prev1
+			}
+			if next[i].prev2 != next[j].prev2 {
+				return next[i].prev2 < next[j].prev2
+			}
+			return next[i].err < next[j].err
+		})
+
+		paths = next[:1]
+		for _, path := range next[1:] {
+			last := paths[len(paths)-1]
+			if path.prev1 != last.prev1 || path.prev2 != last.prev2 {
+				paths = append(paths, path)
+			}
+		}
+
+		sort.SliceStable(paths, func(i, j int) bool {
+			return paths[i].err < paths[j].err
+		})
+		if len(paths) > width {
+			paths = paths[:width]
+		}
+	}
+
+	best := paths[0]
+	return quantizedBlock{
+		nibbles: best.nibbles,
+		err:     best.err,
+		prev1:   best.prev1,
+		prev2:   best.prev2,
+	}, true
+}
@@ -0,0 +1,52 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNibbleSearch(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	pcm := createSinePcm16(640, 25000)
+	for i := range pcm {
+		pcm[i] += int16(random.Intn(4000) - 2000)
+	}
+
+	codec := NewCodec()
+	codec.PcmData = pcm
+	codec.Encode()
+	round := codec.EncodingStats()
+	roundError := nocAbsoluteError(pcm, codec.BrrData)
+	assert.Equal(t, 0.0, round.SearchGain)
+
+	for _, search := range []string{"beam", "exhaustive"} {
+		assert.NoError(t, codec.SetCodecOption("search", search))
+		codec.Encode()
+		stats := codec.EncodingStats()
+
+		assert.Greater(t, stats.SearchGain, 0.0, search)
+		assert.Less(t, nocAbsoluteError(pcm, codec.BrrData), roundError, search)
+	}
+
+	assert.ErrorIs(t, codec.SetCodecOption("search", "fast"), ErrInvalidCodecOptionValue)
+}
+
+func TestNibbleSearchLossless(t *testing.T) {
+	// The search never does worse than rounding.
+	pcm := createLosslessPcm(50)
+
+	codec := NewCodec()
+	codec.PcmData = pcm
+	codec.SetCodecOption("search", "beam")
+	codec.Encode()
+	assert.Equal(t, 0.0, codec.EncodingStats().TotalError)
+
+	codec.Decode()
+	assert.Equal(t, pcm, codec.PcmData)
+}
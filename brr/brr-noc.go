@@ -10,18 +10,40 @@ package brr
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"strconv"
 )
 
+// Number of candidates per block that are followed during a lookahead search.
+const kLookaheadWidth = 4
+
+// Number of filter and range combinations per block, with the lowest rounded error, that
+// are refined by the nibble search.
+const kSearchRefine = 4
+
+// Deepest lookahead allowed. Each level multiplies the encoding time by the width, so
+// the search is kept shallow.
+const kMaxLookahead = 3
+
 type nocCodec struct {
 	opts      map[string]string
 	stats     EncodingStats
 	loopPoint int
 	hasLoop   bool
+	lookahead int
+	search    string
+	loopFit   bool
+
+	// Decode the way the S-DSP does, with 15-bit wraparound.
+	hw bool
+
+	// Keep decoded samples from overflowing the gaussian interpolation.
+	gaussGuard bool
 }
 
 func createNocCodec() *nocCodec {
-	return &nocCodec{}
+	return &nocCodec{search: "round", gaussGuard: true}
 }
 
 func (c *nocCodec) Setopt(name string, value string) error {
@@ -33,6 +55,34 @@ func (c *nocCodec) Setopt(name string, value string) error {
 			c.loopPoint = lp
 			c.hasLoop = c.loopPoint >= 0
 		}
+	case "lookahead":
+		if depth, err := strconv.Atoi(value); err != nil || depth < 0 || depth > kMaxLookahead {
+			return fmt.Errorf("%w: lookahead must be 0-%d", ErrInvalidCodecOptionValue,
+				kMaxLookahead)
+		} else {
+			c.lookahead = depth
+		}
+	case "search":
+		if value != "round" && value != "beam" && value != "exhaustive" {
+			return fmt.Errorf("%w: search must be round, beam, or exhaustive",
+				ErrInvalidCodecOptionValue)
+		}
+		c.search = value
+	case "loopfit":
+		if value != "0" && value != "1" {
+			return fmt.Errorf("%w: loopfit must be 0 or 1", ErrInvalidCodecOptionValue)
+		}
+		c.loopFit = value == "1"
+	case "hw":
+		if value != "0" && value != "1" {
+			return fmt.Errorf("%w: hw must be 0 or 1", ErrInvalidCodecOptionValue)
+		}
+		c.hw = value == "1"
+	case "gaussguard":
+		if value != "0" && value != "1" {
+			return fmt.Errorf("%w: gaussguard must be 0 or 1", ErrInvalidCodecOptionValue)
+		}
+		c.gaussGuard = value == "1"
 	default:
 		return fmt.Errorf("%w: %s", ErrUnknownCodecOption, name)
 	}
@@ -69,17 +119,129 @@ func filterBase(prev1 int, prev2 int, filter int) int {
 	return 0
 }
 
-// Encode a block. prev1 & prev2 are the previous two decoded 15-bit samples.
+// Returns true if the decoded 15-bit sample s, following prev2 and prev1, makes three
+// samples in a row that overflow the gaussian interpolation at some pitches, which
+// inverts the sign of the output. See testOverflow. Filter 0 can't decode closer to full
+// scale than 7 << 11, which is never part of such a run, so a filter 0 block is safe from
+// any history.
+func gaussOverflows(prev2 int, prev1 int, s int) bool {
+	return testOverflow([]int16{int16(prev2), int16(prev1), int16(s)}) != 0
+}
+
+// One way to encode a BRR block.
+type blockCandidate struct {
+	// The 9-byte BRR block.
+	data []byte
+
+	// Sum of the absolute errors of the decoded samples, in 15-bit units.
+	err int
+
+	// Error of the block with rounded quantization, for measuring the nibble search.
+	roundErr int
+
+	// The last two decoded 15-bit samples, for the next block.
+	prev1 int
+	prev2 int
+}
+
+// The 4-bit samples of a block quantized with a given filter and shift.
+type quantizedBlock struct {
+	nibbles [16]int
+
+	// Sum of the absolute errors of the decoded samples, in 15-bit units.
+	err int
+
+	// The last two decoded 15-bit samples.
+	prev1 int
+	prev2 int
+}
+
+// Quantize each sample by rounding it to the nearest 4-bit value. This is the best choice
+// for each sample on its own, but not always for the block, since each sample feeds into
+// the filter prediction of the next. Fails if a sample can't be kept in range, or with
+// the gaussian guard, if it can't be kept from overflowing the gaussian interpolation.
+func (c *nocCodec) quantizeRound(pcmData []int16, prev1 int, prev2 int, filter int,
+	shift int) (quantizedBlock, bool) {
+
+	q := quantizedBlock{prev1: prev1, prev2: prev2}
+	half := 1 << shift >> 1
+
+	for p := 0; p < 16; p++ {
+		desiredSample := int(pcmData[p]) >> 1
+
+		base := filterBase(q.prev1, q.prev2, filter)
+		delta := desiredSample - base
+
+		brrSample := (delta + half) >> shift
+		if brrSample < -8 {
+			brrSample = -8
+		} else if brrSample > 7 {
+			brrSample = 7
+		}
+
+		for {
+			nextDecodedSample := base + (brrSample << shift)
+
+			// If the sample is out of range, try again with a lesser value. Note this
+			// is naive and we could do more efficient math than
+			// incrementing/decrementing.
+			if nextDecodedSample < -0x3FFA {
+				if brrSample < 7 {
+					brrSample++
+					continue
+				} else {
+					return q, false
+				}
+			} else if nextDecodedSample > 0x3FF8 {
+				if brrSample > -8 {
+					brrSample--
+					continue
+				} else {
+					return q, false
+				}
+			} else if c.gaussGuard && gaussOverflows(q.prev2, q.prev1, nextDecodedSample) {
+				// Move toward zero until the run of samples is safe.
+				if nextDecodedSample < 0 && brrSample < 7 {
+					brrSample++
+					continue
+				} else if nextDecodedSample > 0 && brrSample > -8 {
+					brrSample--
+					continue
+				}
+				return q, false
+			}
+			break
+		}
+
+		nextDecodedSample := base + (brrSample << shift)
+		errAmount := (desiredSample - nextDecodedSample)
+		if errAmount < 0 {
+			errAmount = -errAmount
+		}
+		q.err += errAmount
+		q.nibbles[p] = brrSample
+		q.prev2 = q.prev1
+		q.prev1 = nextDecodedSample
+	}
+
+	return q, true
+}
+
+// Returns the encodings of a block for each filter and range that doesn't fail. prev1 &
+// prev2 are the previous two decoded 15-bit samples.
 //
 // noFilter forces use of filter 0, to avoid unexpected output for the start and loop
 // point (when prev1 and prev2 are variable).
-func (c *nocCodec) encodeBlock(pcmData []int16, prev1 int, prev2 int, noFilter bool,
-	stats *EncodingStats) ([]byte, int, int) {
-
-	bestOutput := []byte{}
-	bestError := 0x7FFFFFFF
-	bestPrev1 := 0
-	bestPrev2 := 0
+func (c *nocCodec) blockCandidates(pcmData []int16, prev1 int, prev2 int,
+	noFilter bool) []blockCandidate {
+
+	type quantized struct {
+		quantizedBlock
+		filter   int
+		shift    int
+		roundErr int
+	}
+	blocks := []quantized{}
 
 	filterEnd := 3
 	if noFilter {
@@ -90,99 +252,142 @@ func (c *nocCodec) encodeBlock(pcmData []int16, prev1 int, prev2 int, noFilter b
 
 		// Shift range = 1 + 0-11. Range 0 is unused.
 		for shift := 11; shift >= 0; shift-- {
-			half := 1 << shift >> 1
+			if q, ok := c.quantizeRound(pcmData, prev1, prev2, filter, shift); ok {
+				blocks = append(blocks, quantized{q, filter, shift, q.err})
+			}
+		}
+	}
 
-			fprev1 := prev1
-			fprev2 := prev2
+	if c.search != "round" {
+		// Refine the most promising filters and ranges with the nibble search.
+		order := make([]int, len(blocks))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return blocks[order[i]].err < blocks[order[j]].err
+		})
+		if len(order) > kSearchRefine {
+			order = order[:kSearchRefine]
+		}
 
-			foutput := []byte{byte(((shift + 1) << 4) | (filter << 2))}
-			fErrorSum := 0
-			failEncoding := false
+		for _, i := range order {
+			b := &blocks[i]
+			if q, ok := c.searchNibbles(pcmData, prev1, prev2, b.filter, b.shift, b.err); ok &&
+				q.err < b.err {
+				b.quantizedBlock = q
+			}
+		}
+	}
 
-			for p := 0; p < 16; p++ {
-				desiredSample := int(pcmData[p]) >> 1
+	candidates := []blockCandidate{}
+	for _, b := range blocks {
+		data := []byte{byte(((b.shift + 1) << 4) | (b.filter << 2))}
+		for i := 0; i < 8; i++ {
+			data = append(data, byte(b.nibbles[i*2]<<4)|byte(b.nibbles[i*2+1]&0xF))
+		}
 
-				base := filterBase(fprev1, fprev2, filter)
-				delta := desiredSample - base
+		candidates = append(candidates, blockCandidate{
+			data:     data,
+			err:      b.err,
+			roundErr: b.roundErr,
+			prev1:    b.prev1,
+			prev2:    b.prev2,
+		})
+	}
 
-				brrSample := (delta + half) >> shift
-				if brrSample < -8 {
-					brrSample = -8
-				} else if brrSample > 7 {
-					brrSample = 7
-				}
+	return candidates
+}
 
-				for {
-					nextDecodedSample := base + (brrSample << shift)
-
-					// If the sample is out of range, try again with a lesser value. Note this
-					// is naive and we could do more efficient math than
-					// incrementing/decrementing.
-					if nextDecodedSample < -0x3FFA {
-						if brrSample < 7 {
-							brrSample++
-							continue
-						} else {
-							failEncoding = true
-							break
-						}
-					} else if nextDecodedSample > 0x3FF8 {
-						if brrSample > -8 {
-							brrSample--
-							continue
-						} else {
-							failEncoding = true
-							break
-						}
-					}
-					break
-				}
+// Returns the candidate with the lowest error. For equal errors, the first candidate is
+// preferred, which favors less complex filters and higher ranges.
+func bestCandidate(candidates []blockCandidate) blockCandidate {
+	best := candidates[0]
+	for _, cand := range candidates[1:] {
+		if cand.err < best.err {
+			best = cand
+		}
+	}
+	return best
+}
 
-				if failEncoding {
-					break
-				}
+// Returns the lowest total error of encoding the next depth blocks starting at readPos.
+// Only the best few candidates of each block are followed to keep the search
+// manageable.
+func (c *nocCodec) lookaheadError(pcmData []int16, readPos int, loopPoint int,
+	prev1 int, prev2 int, depth int) int {
 
-				nextDecodedSample := base + (brrSample << shift)
-				// Todo: a better formula to determine severity of error?
-				errAmount := (desiredSample - nextDecodedSample)
-				if errAmount < 0 {
-					errAmount = -errAmount
-				}
-				fErrorSum += errAmount
-				foutput = append(foutput, byte(brrSample&0xF))
-				fprev2 = fprev1
-				fprev1 = nextDecodedSample
-			}
+	if depth == 0 || readPos >= len(pcmData) {
+		return 0
+	}
 
-			if failEncoding {
-				continue
-			}
+	noFilter := readPos == 0 || readPos == loopPoint
+	candidates := c.blockCandidates(pcmData[readPos:readPos+16], prev1, prev2, noFilter)
+	candidates = lowestCandidates(candidates, kLookaheadWidth)
 
-			if fErrorSum < bestError {
-				bestError = fErrorSum
-				bestOutput = foutput
-				bestPrev1 = fprev1
-				bestPrev2 = fprev2
-			}
+	best := math.MaxInt
+	for _, cand := range candidates {
+		total := cand.err
+		if total >= best {
+			break
+		}
+		total += c.lookaheadError(pcmData, readPos+16, loopPoint, cand.prev1, cand.prev2, depth-1)
+		if total < best {
+			best = total
 		}
 	}
+	return best
+}
 
-	for i := 0; i < 8; i++ {
-		bestOutput[1+i] = (bestOutput[1+i*2] << 4) | (bestOutput[1+i*2+1] & 0xF)
+// Returns up to count candidates with the lowest error, in order of error.
+func lowestCandidates(candidates []blockCandidate, count int) []blockCandidate {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].err < candidates[j].err
+	})
+	if len(candidates) > count {
+		candidates = candidates[:count]
 	}
+	return candidates
+}
 
-	stats.TotalError += float64(bestError)
-	stats.AvgError += float64(bestError / 16)
+// Encode the block at readPos. Without lookahead, this picks the block encoding with the
+// lowest error. With lookahead, the choice also considers how the decoded samples affect
+// the error of the following blocks through their filters.
+func (c *nocCodec) encodeBlock(pcmData []int16, readPos int, loopPoint int, prev1 int,
+	prev2 int) blockCandidate {
+
+	noFilter := readPos == 0 || readPos == loopPoint
+	candidates := c.blockCandidates(pcmData[readPos:readPos+16], prev1, prev2, noFilter)
+
+	if c.lookahead == 0 {
+		return bestCandidate(candidates)
+	}
 
-	return bestOutput[0:9], bestPrev1, bestPrev2
+	candidates = lowestCandidates(candidates, kLookaheadWidth)
+	best := candidates[0]
+	bestError := math.MaxInt
+	for _, cand := range candidates {
+		total := cand.err + c.lookaheadError(pcmData, readPos+16, loopPoint, cand.prev1,
+			cand.prev2, c.lookahead)
+		if total < bestError {
+			bestError = total
+			best = cand
+		}
+	}
+
+	return best
 }
 
-func (c *nocCodec) Encode(pcmData []int16) []byte {
+func (c *nocCodec) Encode(pcmData []int16) ([]byte, EncodeResult) {
 	output := []byte{}
-	c.stats = EncodingStats{}
+	result := EncodeResult{OriginalLength: len(pcmData)}
 
 	loopPoint := c.getLoopOpt()
 
+	if loopPoint >= len(pcmData) {
+		loopPoint = -1
+	}
+
 	if loopPoint >= 0 {
 		// Align loop start to 16 samples
 		for loopPoint&15 != 0 {
@@ -197,6 +402,7 @@ func (c *nocCodec) Encode(pcmData []int16) []byte {
 			pcmData = append(pcmData, loopRegion...)
 		}
 
+		result.UnrolledSamples = len(pcmData) - result.OriginalLength
 	} else {
 		// Pad end to 16 samples
 		for len(pcmData)&15 != 0 {
@@ -207,12 +413,24 @@ func (c *nocCodec) Encode(pcmData []int16) []byte {
 	prev1 := 0
 	prev2 := 0
 
+	// Search gain of each block.
+	gains := []int{}
+
 	for readPos := 0; readPos < len(pcmData); readPos += 16 {
-		noFilter := readPos == 0 || readPos == loopPoint
-		block, p1, p2 := c.encodeBlock(pcmData[readPos:readPos+16], prev1, prev2, noFilter, &c.stats)
-		prev1 = p1
-		prev2 = p2
-		output = append(output, block...)
+		block := c.encodeBlock(pcmData, readPos, loopPoint, prev1, prev2)
+		prev1 = block.prev1
+		prev2 = block.prev2
+		gains = append(gains, block.roundErr-block.err)
+		output = append(output, block.data...)
+	}
+
+	if c.loopFit && loopPoint >= 0 {
+		c.fitLoop(pcmData, loopPoint, output, gains)
+	}
+
+	searchGain := 0
+	for _, gain := range gains {
+		searchGain += gain
 	}
 
 	if len(output) == 0 {
@@ -221,16 +439,34 @@ func (c *nocCodec) Encode(pcmData []int16) []byte {
 
 	output[len(output)-9] |= 0x01
 
+	result.LoopBlock = -1
+	result.LoopOffset = -1
 	if loopPoint >= 0 {
 		output[len(output)-9] |= 0x02
+		result.LoopBlock = loopPoint / 16
+		result.LoopOffset = result.LoopBlock * 9
 	}
+	result.PaddedLength = len(output) / 9 * 16
+
+	c.stats = measureEncoding(pcmData, output)
+
+	// 15-bit to 16-bit error.
+	c.stats.SearchGain = float64(searchGain) * 2
 
-	return output
+	return output, result
 }
 
 func (c *nocCodec) decodeBlock(block []byte, prev1 int, prev2 int) ([]int16, int, int) {
 	output := []int16{}
 
+	if c.hw {
+		samples, prev1, prev2, _ := decodeBlockHw(block, prev1, prev2)
+		for _, s := range samples {
+			output = append(output, int16(s<<1))
+		}
+		return output, prev1, prev2
+	}
+
 	shift := block[0] >> 4
 	filter := int((block[0] >> 2) & 0x03)
 
@@ -252,7 +488,7 @@ func (c *nocCodec) decodeBlock(block []byte, prev1 int, prev2 int) ([]int16, int
 		nextDecodedSample := (base + unpackedSample)
 
 		// Undefined behavior if nextDecodedSample is overflowing a sane range. We don't
-		// clamp it in this implementation.
+		// clamp it in this implementation. ValidateBrr reports where it happens.
 
 		pcmSample := nextDecodedSample << 1
 
@@ -300,3 +536,25 @@ func (c *nocCodec) Decode(brrData []byte) ([]int16, SampleRate) {
 func (c *nocCodec) EncodingStats() EncodingStats {
 	return c.stats
 }
+
+// Returns the number of samples that Encode produces for the given input length and loop
+// start, after alignment and unrolling. This follows the same steps as Encode.
+func (c *nocCodec) EncodedLength(length int, loopStart int) int {
+	if loopStart >= length {
+		loopStart = -1
+	}
+
+	if loopStart >= 0 {
+		loopSize := length - loopStart
+		length += (16 - loopStart&15) & 15
+		for length&15 != 0 {
+			length += loopSize
+		}
+	}
+
+	length = (length + 15) &^ 15
+	if length == 0 {
+		length = 16
+	}
+	return length
+}
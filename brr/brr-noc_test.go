@@ -138,3 +138,140 @@ func TestNocDecoding(t *testing.T) {
 	codec.Decode()
 	assert.Equal(t, pcm, codec.PcmData)
 }
+
+// Returns the sum of the absolute differences between the input and the BRR data decoded
+// by the noc codec, which is what the encoder minimizes.
+func nocAbsoluteError(pcm []int16, brrData []byte) int {
+	decoded, _ := createNocCodec().Decode(brrData)
+	total := 0
+	for i, s := range pcm {
+		total += absInt(int(s) - int(decoded[i]))
+	}
+	return total
+}
+
+func TestNocLookahead(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	pcm := createSinePcm16(3200, 25000)
+	for i := range pcm {
+		pcm[i] += int16(random.Intn(2000) - 1000)
+	}
+
+	codec := NewCodec()
+	codec.PcmData = pcm
+	codec.Encode()
+	greedy := codec.EncodingStats()
+	greedyError := nocAbsoluteError(pcm, codec.BrrData)
+
+	assert.NoError(t, codec.SetCodecOption("lookahead", "2"))
+	codec.Encode()
+	lookahead := codec.EncodingStats()
+
+	assert.Equal(t, greedy.Blocks, lookahead.Blocks)
+	assert.Less(t, nocAbsoluteError(pcm, codec.BrrData), greedyError)
+
+	// The result still decodes the same on hardware.
+	codec.Decode()
+	assert.Len(t, codec.PcmData, 3200)
+
+	assert.ErrorIs(t, codec.SetCodecOption("lookahead", "4"), ErrInvalidCodecOptionValue)
+}
+
+// Decodes the BRR data with the dmv decoder without interpolation, which models the
+// hardware exactly. Its output is 3 samples late, so it's lined up with the noc output.
+func decodeDmvReference(brrData []byte) []int16 {
+	c := createDmvCodec()
+	c.Setopt("gauss", "0")
+	pcm, _ := c.Decode(append([]byte{}, brrData...))
+	return pcm[3:]
+}
+
+func TestNocHardwareDecoding(t *testing.T) {
+	noc := createNocCodec()
+	assert.NoError(t, noc.Setopt("hw", "1"))
+	assert.ErrorIs(t, noc.Setopt("hw", "2"), ErrInvalidCodecOptionValue)
+
+	// Random blocks use every range and filter, and often overflow.
+	random := rand.New(rand.NewSource(1))
+	for trial := 0; trial < 200; trial++ {
+		brrData := make([]byte, 9*(1+random.Intn(20)))
+		random.Read(brrData)
+		for b := 0; b < len(brrData); b += 9 {
+			brrData[b] &^= 1
+		}
+		brrData[len(brrData)-9] |= 1
+
+		pcm, _ := noc.Decode(brrData)
+		reference := decodeDmvReference(brrData)
+		assert.Equal(t, reference, pcm[:len(reference)], "trial %d", trial)
+	}
+
+	// Filter 3 at full scale wraps around, which the default decoder doesn't model.
+	brrData := []byte{
+		0xC0, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77,
+		0xCD, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77,
+	}
+	hw, _ := noc.Decode(brrData)
+	assert.Equal(t, decodeDmvReference(brrData), hw[:len(hw)-3])
+	assert.Negative(t, hw[16])
+	plain, _ := createNocCodec().Decode(brrData)
+	assert.NotEqual(t, plain, hw)
+
+	// The encoder keeps to the range where both decoders agree.
+	codec := NewCodec()
+	codec.PcmData = createSinePcm16(3200, 32767)
+	codec.Encode()
+	codec.Decode()
+	plain = codec.PcmData
+
+	codec.SetCodecOption("hw", "1")
+	codec.Decode()
+	assert.Equal(t, plain, codec.PcmData)
+}
+
+func TestNocGaussGuard(t *testing.T) {
+	// Silence and then full scale, which the encoder holds close to full scale. The loop
+	// is first reached from silence, but the later passes are reached from full scale.
+	pcm := make([]int16, 1600)
+	for i := 800; i < len(pcm); i++ {
+		pcm[i] = -32768
+	}
+
+	// Counts the gaussian overflows, including those on later passes through the loop.
+	countOverflows := func(loop int, loopFit string, search string, guard string) int {
+		codec := NewCodec()
+		codec.PcmData = pcm
+		codec.SetLoop(loop)
+		assert.NoError(t, codec.SetCodecOption("loopfit", loopFit))
+		assert.NoError(t, codec.SetCodecOption("search", search))
+		assert.NoError(t, codec.SetCodecOption("gaussguard", guard))
+		codec.Encode()
+
+		diagnostics, err := ValidateBrr(codec.BrrData, codec.LoopOffset)
+		assert.NoError(t, err)
+		count := 0
+		for _, d := range diagnostics {
+			if d.Kind == KindGaussianOverflow {
+				count++
+			}
+		}
+		return count
+	}
+
+	// 1584 loops the last block onto itself.
+	for _, loop := range []int{800, 1584} {
+		assert.NotZero(t, countOverflows(loop, "0", "round", "0"))
+		assert.Zero(t, countOverflows(loop, "0", "round", "1"), loop)
+		assert.Zero(t, countOverflows(loop, "0", "beam", "1"), loop)
+		assert.Zero(t, countOverflows(loop, "1", "round", "1"), loop)
+		assert.Zero(t, countOverflows(loop, "1", "exhaustive", "1"), loop)
+	}
+
+	// The loop block normally uses filter 0, which can't make a run that overflows.
+	assert.True(t, gaussOverflows(-0x3FFA, -0x3FFA, -0x3FFA))
+	assert.False(t, gaussOverflows(-0x3FFA, -0x3FFA, -7<<11))
+	assert.False(t, gaussOverflows(-0x3FFA, -7<<11, -0x3FFA))
+	assert.False(t, gaussOverflows(-7<<11, -0x3FFA, -0x3FFA))
+
+	assert.ErrorIs(t, createNocCodec().Setopt("gaussguard", "yes"), ErrInvalidCodecOptionValue)
+}
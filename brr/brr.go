@@ -8,6 +8,7 @@ package brr
 
 import (
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"strconv"
@@ -25,6 +26,13 @@ var ErrInvalidWav = errors.New("invalid wav file")
 // Returned when importing a wav file with formats that are unsupported.
 var ErrUnsupportedWav = errors.New("unsupported wav file")
 
+// Returned when BRR data can't be parsed, e.g., a loop header that points outside of the
+// sample.
+var ErrInvalidBrr = errors.New("invalid brr data")
+
+// Returned when an unknown BRR file format is specified.
+var ErrUnknownFormat = errors.New("unknown brr format")
+
 // Returned when an unknown codec is specified.
 var ErrUnknownCodec = errors.New("unknown codec")
 
@@ -34,19 +42,81 @@ var ErrUnknownCodecOption = errors.New("unknown codec option")
 // Returned when an invalid codec option value is specified.
 var ErrInvalidCodecOptionValue = errors.New("invalid option value")
 
-// Collects information about the last encoding operation.
+// Collects information about the last encoding operation. Errors are the squared
+// differences between the input and the decoded output, in 16-bit PCM units, summed per
+// 16-sample block.
 type EncodingStats struct {
+	// Sum of the error of all blocks.
 	TotalError float64
-	AvgError   float64
-	MinError   float64
-	MaxError   float64
+
+	// Average error per block.
+	AvgError float64
+
+	// Lowest and highest error of a single block.
+	MinError float64
+	MaxError float64
+
+	// Largest difference between an input sample and its decoded sample.
+	PeakError float64
+
+	// Signal-to-noise ratio of the decoded output, in dB. +Inf when there is no error.
+	SNR float64
+
+	// Number of samples that were out of reach of their block's range and filter, so the
+	// encoder had to saturate them.
+	ClippedSamples int
+
+	// Number of BRR blocks encoded.
+	Blocks int
+
+	// Number of blocks that use each filter (0-3) and range (0-15).
+	FilterHistogram [4]int
+	RangeHistogram  [16]int
+
+	// How much lower the total absolute error is from the nibble search, compared to
+	// rounding each sample with the same filters and ranges. Only measured by the noc
+	// codec when the "search" option is used.
+	SearchGain float64
+}
+
+// Describes the BRR data produced by an encoding, e.g., for building sample directory
+// entries.
+type EncodeResult struct {
+	// Index of the BRR block that the loop starts on, or -1 if there is no loop.
+	LoopBlock int
+
+	// Byte offset of the loop block within the BRR data, or -1 if there is no loop.
+	LoopOffset int
+
+	// Number of samples added after the end of the input to align the loop start and
+	// unroll the loop to a multiple of 16 samples. Padding a sample without a loop is not
+	// counted.
+	UnrolledSamples int
+
+	// Number of PCM samples given to the encoder, after any resampling.
+	OriginalLength int
+
+	// Number of PCM samples that were encoded, including all padding and unrolling. This
+	// is 16 samples per BRR block.
+	PaddedLength int
+
+	// Pitch change of the loop, in cents, when the loop is aligned by resampling.
+	// Positive when the loop plays higher than the input.
+	LoopPitchCents float64
+
+	// Largest difference, in 16-bit PCM units, between the first pass through the loop
+	// and the following passes. The loop block is re-entered with the filter history
+	// left by the last block, so a filtered loop block can sound different after the
+	// first pass. 0 if there is no loop or it always sounds the same.
+	LoopMismatch int
 }
 
 type codecImpl interface {
 	Setopt(name string, value string) error
-	Encode(data []int16) []byte
+	Encode(data []int16) ([]byte, EncodeResult)
 	Decode(data []byte) ([]int16, SampleRate)
 	EncodingStats() EncodingStats
+	EncodedLength(length int, loopStart int) int
 }
 
 // Encodes and decodes between BRR and PCM. Buffers are kept entirely in memory.
@@ -56,16 +126,56 @@ type BrrCodec struct {
 	// the reading methods.
 	PcmData []int16
 
-	// The sample rate of the PCM data. Usually 32000 but codecs can change this depending
-	// on the options during decoding. Not used for encoding.
+	// The sample rate of the PCM data. Reading a wav sets this to the rate of the file.
+	// Codecs can change this depending on the options during decoding. During encoding,
+	// the PCM data is resampled from this rate if a target rate is set.
 	PcmRate SampleRate
 
 	// 8-bit BRR data, used during decoding. This can be set directly or through the
 	// reading methods.
 	BrrData []byte
 
+	// Byte offset of the loop block within BrrData, or -1 if there is no loop. This is
+	// set by Encode and when reading BRR files that have a loop header.
+	LoopOffset int
+
 	// The underlying codec implementation.
 	codec codecImpl
+
+	// File format used by ReadBrr and WriteBrr.
+	brrFormat string
+
+	// Label for source output formats, or "" to use the file name.
+	sourceLabel string
+
+	// Package name for Go source output, or "" for the default.
+	sourcePackage string
+
+	// How multichannel wav input is mixed down when reading.
+	mixMode    mixMode
+	mixWeights []float64
+
+	// Information about the last downmix.
+	downmixStats DownmixStats
+
+	// Loop start in PcmData, or -1 for no loop.
+	loopStart int
+
+	// Smooths the loop seam before encoding.
+	crossfade crossfade
+
+	// How the loop is aligned to BRR blocks: "unroll" or "resample".
+	loopAlign string
+
+	// When set, Decode plays the BRR data through an emulated S-DSP voice.
+	voice *VoiceSettings
+
+	// How long Decode plays the BRR data for.
+	playback playback
+
+	// Rate to resample to before encoding. 0 disables resampling.
+	targetRate SampleRate
+	resampler  resampler
 }
 
 // Create a new BRR codec instance and initialize it.
@@ -75,9 +185,9 @@ func NewCodec() *BrrCodec {
 	return &codec
 }
 
-// [Not Implemented] Returns some statistics about the last encoding. During encoding,
-// statistics about the the differences in the encoded samples are recorded. Typically not
-// very useful data unless you are doing some sort of obscure sound synthesis.
+// Returns some statistics about the last encoding. After encoding, the BRR output is
+// decoded and compared against the input to measure the error introduced, and the
+// filters and ranges used are counted. Both codecs measure it the same way.
 func (bc *BrrCodec) EncodingStats() EncodingStats {
 	return bc.codec.EncodingStats()
 }
@@ -103,10 +213,65 @@ func (bc *BrrCodec) EncodingStats() EncodingStats {
 // BRR filters will not be used on the loop block to avoid corrupted output.
 func (bc *BrrCodec) SetLoop(loopStart int) {
 	if loopStart < 0 {
-		bc.codec.Setopt("loop", "")
+		loopStart = -1
 	}
+	bc.loopStart = loopStart
+}
 
-	bc.codec.Setopt("loop", strconv.Itoa(loopStart))
+// Sets up a crossfade that blends the end of the loop into the material leading up to
+// the loop start before encoding, so the seam is smooth no matter where the loop is. The
+// length is in samples of the PCM data, and is limited by the material available on
+// both sides of the loop start. The curve can be "linear" or "equal-power". Equal-power
+// keeps the level steady when the material isn't correlated. Pass a length of 0 to
+// disable the crossfade, which is the default.
+func (bc *BrrCodec) SetLoopCrossfade(length int, curve string) error {
+	cf, err := createCrossfade(length, curve)
+	if err != nil {
+		return err
+	}
+	bc.crossfade = cf
+	return nil
+}
+
+// Sets how a loop that doesn't fit BRR blocks is aligned when encoding.
+//
+//   - "unroll" (the default) pads the start of the loop and repeats the loop until its
+//     length is a multiple of 16 samples. This keeps the pitch exact, but a loop can
+//     grow up to 16 times longer.
+//   - "resample" stretches the loop to the nearest multiple of 16 samples, then moves
+//     the loop start forward to the next block and plays the samples it skips again at
+//     the end of the loop. The start of the sample stays where it is and the size
+//     barely changes, but the pitch of the loop shifts slightly. EncodeResult reports
+//     the shift in cents.
+func (bc *BrrCodec) SetLoopAlign(mode string) error {
+	if mode != "unroll" && mode != "resample" {
+		return fmt.Errorf("%w: %s", ErrInvalidLoopAlign, mode)
+	}
+	bc.loopAlign = mode
+	return nil
+}
+
+// Sets the sample rate that the PCM data is converted to before encoding. The PCM data
+// is assumed to be at PcmRate, which is set when reading a wav. The loop start is
+// converted along with it. Pass 0 to disable resampling, which is the default.
+func (bc *BrrCodec) SetTargetRate(rate SampleRate) {
+	if rate < 0 {
+		rate = 0
+	}
+	bc.targetRate = rate
+}
+
+// Sets the resampling method used when a target rate is set. The method can be "sinc"
+// (windowed-sinc, the default) or "linear". For sinc, quality is the number of zero
+// crossings on each side of the filter kernel, 1-64. Higher is slower but more accurate.
+// Pass 0 for the default quality of 16. Quality is ignored by the linear method.
+func (bc *BrrCodec) SetResampler(method string, quality int) error {
+	r, err := createResampler(method, quality)
+	if err != nil {
+		return err
+	}
+	bc.resampler = r
+	return nil
 }
 
 // Returns the raw PCM data from the last decode operation.
@@ -118,9 +283,61 @@ func (bc *BrrCodec) GetBrrData() []uint8 {
 	return bc.BrrData
 }
 
-// Set an option for the underlying codec.
+// Set an option for the underlying codec. Setting "loop" is the same as SetLoop.
 func (bc *BrrCodec) SetCodecOption(name string, value string) error {
-	return bc.codec.Setopt(name, value)
+	if err := bc.codec.Setopt(name, value); err != nil {
+		return err
+	}
+
+	if name == "loop" {
+		loopStart, _ := strconv.Atoi(value)
+		bc.SetLoop(loopStart)
+	}
+	return nil
+}
+
+// Sets how multichannel wav input is mixed down to one channel when reading. The mix can
+// be "average" (the default), "left", "right", "mid", "side", or a comma separated list
+// of per-channel weights such as "0.7,0.3".
+func (bc *BrrCodec) SetChannelMix(mix string) error {
+	if mode, err := parseMixMode(mix); err == nil {
+		bc.mixMode = mode
+		bc.mixWeights = nil
+		return nil
+	}
+
+	weights, err := parseMixWeights(mix)
+	if err != nil {
+		return err
+	}
+	bc.SetChannelWeights(weights)
+	return nil
+}
+
+// Sets the weight of each channel when mixing multichannel wav input. Channels without a
+// weight are left out of the mix. Reading a wav fails if a nonzero weight is given for a
+// channel that doesn't exist.
+func (bc *BrrCodec) SetChannelWeights(weights []float64) {
+	bc.mixMode = mixWeights
+	bc.mixWeights = append([]float64{}, weights...)
+}
+
+// Selects a single channel to read from multichannel wav input. Channel 0 is the left
+// channel.
+func (bc *BrrCodec) SetChannel(channel int) error {
+	if channel < 0 {
+		return fmt.Errorf("%w: channel %d", ErrInvalidChannelMix, channel)
+	}
+	weights := make([]float64, channel+1)
+	weights[channel] = 1
+	bc.SetChannelWeights(weights)
+	return nil
+}
+
+// Returns information about the channel downmix from the last wav read, including
+// whether phase cancellation was detected.
+func (bc *BrrCodec) DownmixStats() DownmixStats {
+	return bc.downmixStats
 }
 
 // Sets the pitch to be used during decoding. (I'm not sure what this is used for.)
@@ -132,6 +349,13 @@ func (bc *BrrCodec) SetCodecOption(name string, value string) error {
 func (bc *BrrCodec) initialize() {
 	*bc = BrrCodec{}
 	bc.PcmRate = 32000
+	bc.loopStart = -1
+	bc.LoopOffset = -1
+	bc.playback.loopCount = -1
+	bc.brrFormat = "auto"
+	bc.resampler, _ = createResampler("sinc", 0)
+	bc.crossfade, _ = createCrossfade(0, "linear")
+	bc.loopAlign = "unroll"
 	bc.SetCodecImplementation("noc")
 }
 
@@ -151,34 +375,179 @@ func (bc *BrrCodec) SetCodecImplementation(codec string) error {
 	return nil
 }
 
+// Makes Decode play the BRR data through an emulated S-DSP voice instead of the codec's
+// decoder, so the output matches what the SNES plays: pitch, gaussian interpolation, the
+// envelope, and volume are applied, and the output is always 32000 Hz. The decoder works
+// the same for any codec's output. Pass nil to go back to the codec's decoder.
+//
+// Like the hardware, a block with the END flag and no LOOP flag silences the voice as
+// soon as it is reached, so the last block of a sample without a loop is not heard.
+func (bc *BrrCodec) SetVoice(settings *VoiceSettings) error {
+	if settings == nil {
+		bc.voice = nil
+		return nil
+	}
+
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	copied := *settings
+	bc.voice = &copied
+	return nil
+}
+
+// Makes Decode play the sample for the given number of seconds, following the loop if
+// the last block has the LOOP flag. The output is cut or padded with silence to the
+// exact length. Pass 0 to decode until the end of the sample, which is the default.
+func (bc *BrrCodec) SetPlayDuration(seconds float64) {
+	if seconds < 0 {
+		seconds = 0
+	}
+	bc.playback.seconds = seconds
+}
+
+// Makes Decode jump back to the loop point the given number of times before stopping,
+// if the last block has the LOOP flag, so the loop seam can be heard. When a duration is
+// also set, playback stops at whichever comes first. Pass -1 to loop until the duration
+// runs out, which is the default. Without a duration, a single pass is decoded.
+//
+// The loop point is LoopOffset, or the start of the sample if it's -1.
+func (bc *BrrCodec) SetLoopCount(count int) {
+	if count < 0 {
+		count = -1
+	}
+	bc.playback.loopCount = count
+}
+
 // Decode the data in the BRR buffer into the PCM buffer.
 func (bc *BrrCodec) Decode() {
+	if bc.voice != nil {
+		bc.PcmData, _ = renderVoice(bc.BrrData, bc.LoopOffset, *bc.voice, bc.playback)
+		bc.PcmRate = DspRate
+		return
+	}
+
+	if bc.playback.enabled() {
+		bc.PcmData, bc.PcmRate = decodePlayback(bc.codec, bc.BrrData, bc.LoopOffset,
+			bc.playback)
+		return
+	}
+
 	bc.PcmData, bc.PcmRate = bc.codec.Decode(bc.BrrData)
 }
 
-// Encode the data in the PCM buffer into the BRR buffer.
-func (bc *BrrCodec) Encode() {
-	bc.BrrData = bc.codec.Encode(bc.PcmData)
+// Encode the data in the PCM buffer into the BRR buffer. Returns where the loop ended up
+// in the BRR data and how much the sample grew from loop alignment.
+func (bc *BrrCodec) Encode() EncodeResult {
+	pcmData, loopStart, pitchCents := bc.prepareEncode()
+	bc.codec.Setopt("loop", strconv.Itoa(loopStart))
+
+	var result EncodeResult
+	bc.BrrData, result = bc.codec.Encode(pcmData)
+	result.LoopPitchCents = pitchCents
+	bc.LoopOffset = result.LoopOffset
+	result.LoopMismatch = loopMismatch(bc.BrrData, result.LoopOffset)
+
+	return result
 }
 
-// Load the codec with the given BRR data from a stream.
+// Applies preprocessing to a copy of the PCM buffer before encoding. Returns the
+// processed PCM, the loop start within it, and the pitch change of the loop from aligning
+// it, in cents.
+func (bc *BrrCodec) prepareEncode() ([]int16, int, float64) {
+	pcmData := append([]int16{}, bc.PcmData...)
+	loopStart := bc.loopStart
+	crossfadeLength := bc.crossfade.length
+
+	if bc.targetRate > 0 && bc.PcmRate > 0 && bc.targetRate != bc.PcmRate {
+		pcmData = bc.resampler.resample(pcmData, bc.PcmRate, bc.targetRate)
+		if loopStart >= 0 {
+			loopStart = resampledLength(loopStart, bc.PcmRate, bc.targetRate)
+		}
+		crossfadeLength = resampledLength(crossfadeLength, bc.PcmRate, bc.targetRate)
+	}
+
+	if crossfadeLength > 0 {
+		bc.crossfade.apply(pcmData, loopStart, crossfadeLength)
+	}
+
+	pitchCents := 0.0
+	if bc.loopAlign == "resample" {
+		pcmData, loopStart, pitchCents = alignLoopByResampling(pcmData, loopStart,
+			bc.resampler)
+	}
+
+	return pcmData, loopStart, pitchCents
+}
+
+// Sets the file format used by ReadBrr and WriteBrr.
+//
+//   - "raw" is plain BRR data without any header.
+//   - "amk" is BRR data prefixed with a 2-byte little-endian loop offset, as used by
+//     AddMusicK.
+//   - "auto" (the default) reads files as "amk" when their length is 2 more than a
+//     multiple of 9 (BRR block size), and "raw" otherwise. Writes "raw".
+//   - "ca65", "wla", "asar", "c" and "go" write the data as source text for ca65,
+//     WLA-DX, asar, C or Go, with a label and constants for the length and loop offset.
+//     See SetSourceLabel and SetSourcePackage. These can't be read.
+func (bc *BrrCodec) SetBrrFormat(format string) error {
+	switch format {
+	case "auto", "raw", "amk", "ca65", "wla", "asar", "c", "go":
+		bc.brrFormat = format
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+	return nil
+}
+
+// Load the codec with the given BRR data from a stream. If the data has a loop header,
+// LoopOffset is set from it. Otherwise, LoopOffset is -1.
 func (bc *BrrCodec) ReadBrr(is io.Reader) error {
-	var err error
-	bc.BrrData, err = io.ReadAll(is)
+	if isSourceFormat(bc.brrFormat) {
+		return fmt.Errorf("%w: can't read %s source", ErrUnknownFormat, bc.brrFormat)
+	}
+
+	data, err := io.ReadAll(is)
 	if err != nil {
 		return err
 	}
 
+	format := bc.brrFormat
+	if format == "auto" {
+		format = "raw"
+		if len(data)%9 == 2 {
+			format = "amk"
+		}
+	}
+
+	loopOffset := -1
+	if format == "amk" {
+		if len(data) < 2 {
+			return fmt.Errorf("%w: missing loop header", ErrInvalidBrr)
+		}
+		loopOffset = int(data[0]) | int(data[1])<<8
+		data = data[2:]
+	}
+
 	// Pad to a multiple of 9 (BRR chunk size).
-	for (len(bc.BrrData) % 9) != 0 {
-		bc.BrrData = append(bc.BrrData, 0)
+	for (len(data) % 9) != 0 {
+		data = append(data, 0)
+	}
+
+	// The loop offset only matters if the last block has the loop flag.
+	if len(data) == 0 || data[len(data)-9]&0x02 == 0 {
+		loopOffset = -1
+	} else if format == "amk" && (loopOffset%9 != 0 || loopOffset >= len(data)) {
+		return fmt.Errorf("%w: loop offset %d", ErrInvalidBrr, loopOffset)
 	}
 
+	bc.BrrData = data
+	bc.LoopOffset = loopOffset
 	return nil
 }
 
-// Load the codec with the given BRR data from a file. The data in the file is the raw BRR
-// data without any header.
+// Load the codec with the given BRR data from a file. See SetBrrFormat for the supported
+// file formats.
 func (bc *BrrCodec) ReadBrrFile(filename string) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -188,8 +557,29 @@ func (bc *BrrCodec) ReadBrrFile(filename string) error {
 	return bc.ReadBrr(file)
 }
 
-// Copy the BRR buffer into the given stream.
+// Copy the BRR buffer into the given stream. With the "amk" format, the loop header is
+// written first, using 0 when there is no loop. Source formats are written with the
+// label set by SetSourceLabel, or "brr_sample".
 func (bc *BrrCodec) WriteBrr(os io.Writer) error {
+	return bc.writeBrr(os, bc.labelFor(""))
+}
+
+func (bc *BrrCodec) writeBrr(os io.Writer, label string) error {
+	if isSourceFormat(bc.brrFormat) {
+		return writeBrrSource(os, bc.brrFormat, label, bc.packageName(), bc.BrrData,
+			bc.LoopOffset)
+	}
+
+	if bc.brrFormat == "amk" {
+		loopOffset := bc.LoopOffset
+		if loopOffset < 0 {
+			loopOffset = 0
+		}
+		if _, err := os.Write([]byte{byte(loopOffset), byte(loopOffset >> 8)}); err != nil {
+			return err
+		}
+	}
+
 	_, err := os.Write(bc.BrrData)
 	if err != nil {
 		return err
@@ -197,16 +587,19 @@ func (bc *BrrCodec) WriteBrr(os io.Writer) error {
 	return nil
 }
 
-// Copy the BRR buffer to a file. Existing files will be truncated/overwritten.
+// Copy the BRR buffer to a file. Existing files will be truncated/overwritten. Source
+// formats are labeled with the file name unless a label is set.
 func (bc *BrrCodec) WriteBrrFile(filename string) error {
 	f, err := os.Create(filename)
 	if err != nil {
 		return err
 	}
-	return bc.WriteBrr(f)
+	defer f.Close()
+	return bc.writeBrr(f, bc.labelFor(filename))
 }
 
-// Read the given wav file from a stream into the PCM buffer.
+// Read the given wav file from a stream into the PCM buffer. Multichannel input is mixed
+// down to one channel according to SetChannelMix.
 func (bc *BrrCodec) ReadWav(file io.ReadSeeker) error {
 	decoder := wav.NewDecoder(file)
 	if !decoder.IsValidFile() {
@@ -219,34 +612,51 @@ func (bc *BrrCodec) ReadWav(file io.ReadSeeker) error {
 	}
 
 	intData := data.AsIntBuffer()
+	bc.PcmRate = SampleRate(intData.Format.SampleRate)
+
+	channels := intData.Format.NumChannels
+	if channels < 1 {
+		return ErrInvalidWav
+	}
+
+	weights, err := mixWeightsFor(bc.mixMode, bc.mixWeights, channels)
+	if err != nil {
+		return err
+	}
+
+	interleaved := make([]int16, len(intData.Data))
 
 	if intData.SourceBitDepth == 8 {
-		for i := 0; i < len(intData.Data); i += intData.Format.NumChannels {
-			bc.PcmData = append(bc.PcmData, int16(intData.Data[i])<<8)
+		for i, s := range intData.Data {
+			interleaved[i] = int16(s) << 8
 		}
 	} else if intData.SourceBitDepth == 16 {
-		for i := 0; i < len(intData.Data); i += intData.Format.NumChannels {
-			bc.PcmData = append(bc.PcmData, int16(intData.Data[i]))
+		for i, s := range intData.Data {
+			interleaved[i] = int16(s)
 		}
 	} else if intData.SourceBitDepth == 24 {
-		for i := 0; i < len(intData.Data); i += intData.Format.NumChannels {
-			bc.PcmData = append(bc.PcmData, int16(intData.Data[i]>>8))
+		for i, s := range intData.Data {
+			interleaved[i] = int16(s >> 8)
 		}
 	} else if intData.SourceBitDepth == 32 {
-		for i := 0; i < len(intData.Data); i += intData.Format.NumChannels {
-			bc.PcmData = append(bc.PcmData, int16(intData.Data[i]>>16))
+		for i, s := range intData.Data {
+			interleaved[i] = int16(s >> 16)
 		}
 	} else {
 		return ErrUnsupportedWav
 	}
 
+	bc.PcmData, bc.downmixStats = downmix(interleaved, weights)
+	if bc.mixMode != mixSide && channels > 1 {
+		bc.downmixStats.PhaseCancellation =
+			bc.downmixStats.OutputRms < bc.downmixStats.InputRms*kPhaseCancellationRatio
+	}
+
 	return nil
 }
 
 // Read the given wav file into the PCM buffer.
 func (bc *BrrCodec) ReadWavFile(filename string) error {
-	// TODO: multichannel wavs need to be mixed into one channel.
-
 	file, err := os.Open(filename)
 	if err != nil {
 		return err
@@ -5,12 +5,15 @@
 package brr
 
 import (
+	"bytes"
 	"math"
 	"math/rand"
 	"os"
+	"testing"
 
 	"github.com/go-audio/audio"
 	"github.com/go-audio/wav"
+	"github.com/stretchr/testify/assert"
 )
 
 func createSinePcm16(length int, height float64) []int16 {
@@ -57,3 +60,62 @@ func createWavFile(method string, length int) {
 // func TestBrrCodec_ReadWavFile(t *testing.T) {
 
 // }
+
+func TestAmkFormat(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createSinePcm16(100, 10000)
+	codec.SetLoop(40)
+	codec.SetBrrFormat("amk")
+	codec.Encode()
+
+	// The loop start moves forward to sample 48, the fourth block.
+	assert.Equal(t, 3*9, codec.LoopOffset)
+
+	var buf bytes.Buffer
+	assert.NoError(t, codec.WriteBrr(&buf))
+	assert.Equal(t, []byte{27, 0}, buf.Bytes()[0:2])
+	assert.Equal(t, codec.BrrData, buf.Bytes()[2:])
+
+	// The header is detected automatically from the length.
+	reader := NewCodec()
+	assert.NoError(t, reader.ReadBrr(bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, codec.BrrData, reader.BrrData)
+	assert.Equal(t, 27, reader.LoopOffset)
+
+	// Raw data has no loop information.
+	reader.SetBrrFormat("raw")
+	assert.NoError(t, reader.ReadBrr(bytes.NewReader(codec.BrrData)))
+	assert.Equal(t, -1, reader.LoopOffset)
+
+	// Headers pointing outside of the sample are rejected.
+	bad := append([]byte{0x90, 0x01}, codec.BrrData...)
+	reader.SetBrrFormat("amk")
+	assert.ErrorIs(t, reader.ReadBrr(bytes.NewReader(bad)), ErrInvalidBrr)
+
+	assert.ErrorIs(t, reader.SetBrrFormat("snes"), ErrUnknownFormat)
+}
+
+func TestEncodeResult(t *testing.T) {
+	for _, impl := range []string{"noc", "dmv"} {
+		codec := NewCodec()
+		codec.SetCodecImplementation(impl)
+		codec.PcmData = createSinePcm16(100, 10000)
+
+		result := codec.Encode()
+		assert.Equal(t, -1, result.LoopBlock)
+		assert.Equal(t, -1, result.LoopOffset)
+		assert.Equal(t, 0, result.UnrolledSamples)
+		assert.Equal(t, 100, result.OriginalLength)
+		assert.Equal(t, 112, result.PaddedLength)
+		assert.Len(t, codec.BrrData, 7*9)
+
+		// Loop start 40 is aligned to 48, and the 60-sample loop is unrolled.
+		codec.SetLoop(40)
+		result = codec.Encode()
+		assert.Equal(t, 3, result.LoopBlock, impl)
+		assert.Equal(t, 27, result.LoopOffset, impl)
+		assert.Equal(t, 27, codec.LoopOffset, impl)
+		assert.Equal(t, result.PaddedLength-100, result.UnrolledSamples, impl)
+		assert.Equal(t, len(codec.BrrData)/9*16, result.PaddedLength, impl)
+	}
+}
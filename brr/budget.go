@@ -0,0 +1,142 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned when the sample can't fit in the byte budget, even at the lowest rate.
+var ErrBudgetTooSmall = errors.New("byte budget too small")
+
+// Lowest sample rate that EncodeBudget drops to by default.
+const kDefaultBudgetMinRate SampleRate = 8000
+
+// Describes how EncodeBudget fit the sample in the byte budget.
+type BudgetResult struct {
+	// Sample rate that the PCM data was resampled to. The instrument should be played at
+	// a pitch that matches this rate.
+	Rate SampleRate
+
+	// How the loop was aligned, "unroll" or "resample", or "" if there is no loop.
+	LoopAlign string
+
+	// Number of samples cut from the end of the PCM data, at PcmRate. Samples are only
+	// cut when there is no loop and the lowest rate doesn't fit.
+	TruncatedSamples int
+
+	// Size of the BRR data in bytes.
+	Bytes int
+
+	// The result of the encoding.
+	EncodeResult EncodeResult
+
+	// Quality of the encoding.
+	Stats EncodingStats
+}
+
+// Returns the size in bytes of the BRR data for the PCM data at the given rate, with the
+// given loop alignment.
+func (bc *BrrCodec) budgetSize(length int, rate SampleRate, loopAlign string) int {
+	loopStart := bc.loopStart
+	if loopStart >= length {
+		loopStart = -1
+	}
+
+	if rate != bc.PcmRate {
+		length = resampledLength(length, bc.PcmRate, rate)
+		if loopStart >= 0 {
+			loopStart = resampledLength(loopStart, bc.PcmRate, rate)
+		}
+	}
+
+	if loopStart >= 0 && loopAlign == "resample" && loopStart < length {
+		loopLength := (length - loopStart + 8) &^ 15
+		if loopLength == 0 {
+			loopLength = 16
+		}
+		length = (loopStart+15)&^15 + loopLength
+		loopStart = length - loopLength
+	}
+
+	return bc.codec.EncodedLength(length, loopStart) / 16 * 9
+}
+
+// Encodes the PCM data at the highest sample rate that fits in maxBytes of BRR data. The
+// rate starts at the target rate, or PcmRate if none is set, and goes down to minRate
+// (pass 0 for 8000 Hz). For a looped sample, both loop alignment strategies are tried,
+// and the one that allows the highest rate is used, preferring unrolling on a tie since
+// it keeps the pitch exact. A sample without a loop is cut short if it doesn't fit at
+// minRate. The other encoding settings are used as they are. The target rate and loop
+// alignment are left as they were.
+func (bc *BrrCodec) EncodeBudget(maxBytes int, minRate SampleRate) (BudgetResult, error) {
+	if minRate <= 0 {
+		minRate = kDefaultBudgetMinRate
+	}
+
+	maxRate := bc.PcmRate
+	if bc.targetRate > 0 {
+		maxRate = bc.targetRate
+	}
+	if minRate > maxRate {
+		minRate = maxRate
+	}
+
+	strategies := []string{""}
+	if bc.loopStart >= 0 && bc.loopStart < len(bc.PcmData) {
+		strategies = []string{"unroll", "resample"}
+	}
+
+	result := BudgetResult{}
+	length := len(bc.PcmData)
+	for _, strategy := range strategies {
+		for rate := maxRate; rate > result.Rate && rate >= minRate; rate-- {
+			if bc.budgetSize(length, rate, strategy) <= maxBytes {
+				result.Rate = rate
+				result.LoopAlign = strategy
+				break
+			}
+		}
+	}
+
+	if result.Rate == 0 {
+		if bc.loopStart >= 0 {
+			return result, fmt.Errorf("%w: %d bytes", ErrBudgetTooSmall, maxBytes)
+		}
+
+		result.Rate = minRate
+		for length > 0 && bc.budgetSize(length, minRate, "") > maxBytes {
+			// Cut whole blocks until it fits.
+			length -= resampledLength(16, minRate, bc.PcmRate)
+		}
+		if length <= 0 {
+			return result, fmt.Errorf("%w: %d bytes", ErrBudgetTooSmall, maxBytes)
+		}
+		result.TruncatedSamples = len(bc.PcmData) - length
+	}
+
+	pcmData, targetRate, loopAlign := bc.PcmData, bc.targetRate, bc.loopAlign
+	defer func() {
+		bc.PcmData, bc.targetRate, bc.loopAlign = pcmData, targetRate, loopAlign
+	}()
+
+	bc.PcmData = pcmData[:length]
+	bc.targetRate = result.Rate
+	if result.LoopAlign != "" {
+		bc.loopAlign = result.LoopAlign
+	}
+
+	result.EncodeResult = bc.Encode()
+	result.Bytes = len(bc.BrrData)
+	result.Stats = bc.EncodingStats()
+
+	if result.Bytes > maxBytes {
+		return result, fmt.Errorf("%w: %d bytes, encoded to %d", ErrBudgetTooSmall,
+			maxBytes, result.Bytes)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,95 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodedLength(t *testing.T) {
+	for _, impl := range []string{"noc", "dmv", "dmv compat"} {
+		codec := NewCodec()
+		codec.SetCodecImplementation(impl[:3])
+		if impl == "dmv compat" {
+			codec.SetCodecOption("compat", "1")
+		}
+
+		for i := 0; i < 50; i++ {
+			length := rand.Intn(300) + 1
+			loopStart := rand.Intn(length+10) - 10
+			codec.PcmData = createSinePcm16(length, 1000)
+			codec.SetLoop(loopStart)
+			result := codec.Encode()
+
+			assert.Equal(t, result.PaddedLength, codec.codec.EncodedLength(length, loopStart),
+				"%s length %d loop %d", impl, length, loopStart)
+		}
+	}
+}
+
+func TestEncodeBudget(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createSinePcm16(32000, 10000)
+
+	// 1000 blocks is 16000 samples.
+	result, err := codec.EncodeBudget(9000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, SampleRate(16000), result.Rate)
+	assert.Equal(t, 9000, result.Bytes)
+	assert.Zero(t, result.TruncatedSamples)
+	assert.Equal(t, "", result.LoopAlign)
+	assert.Greater(t, result.Stats.SNR, 20.0)
+
+	// The settings are left alone.
+	assert.Len(t, codec.PcmData, 32000)
+	assert.Zero(t, codec.targetRate)
+
+	// At 8000 Hz, 100 blocks is 0.2 seconds.
+	result, err = codec.EncodeBudget(900, 8000)
+	assert.NoError(t, err)
+	assert.Equal(t, SampleRate(8000), result.Rate)
+	assert.Equal(t, 900, result.Bytes)
+	assert.Equal(t, 32000-6400, result.TruncatedSamples)
+}
+
+func TestEncodeBudgetDmvCompat(t *testing.T) {
+	// The dmv codec in compat mode adds a block to samples without a loop, which has to
+	// be counted against the budget.
+	codec := NewCodec()
+	codec.SetCodecImplementation("dmv")
+	assert.NoError(t, codec.SetCodecOption("compat", "1"))
+	codec.PcmData = createSinePcm16(32000, 10000)
+
+	result, err := codec.EncodeBudget(9000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 9000, result.Bytes)
+	assert.Equal(t, SampleRate(15984), result.Rate)
+}
+
+func TestEncodeBudgetLoop(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createPeriodicPcm16(16000, 100)
+	codec.SetLoop(1003)
+
+	// Unrolling the 14997 sample loop doesn't fit, but resampling does.
+	result, err := codec.EncodeBudget(9000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "resample", result.LoopAlign)
+	assert.Greater(t, result.Rate, SampleRate(15000))
+	assert.Equal(t, "unroll", codec.loopAlign)
+
+	// An aligned loop doesn't need resampling.
+	codec.SetLoop(1008)
+	codec.PcmData = codec.PcmData[:16000-16]
+	result, err = codec.EncodeBudget(9000, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "unroll", result.LoopAlign)
+
+	_, err = codec.EncodeBudget(90, 0)
+	assert.ErrorIs(t, err, ErrBudgetTooSmall)
+}
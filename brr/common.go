@@ -72,3 +72,55 @@ var kGaussTable = []int16{
 	0x513, 0x514, 0x514, 0x515, 0x516, 0x516, 0x517, 0x517,
 	0x517, 0x518, 0x518, 0x518, 0x518, 0x518, 0x519, 0x519,
 }
+
+// Returns the signed 4-bit value of sample i (0-15) of a BRR block. The high nibble of
+// each byte comes first.
+func blockNibble(block []byte, i int) int {
+	nibble := int(block[1+i/2])
+	if i&1 == 0 {
+		nibble >>= 4
+	}
+	return int(int8(nibble<<4) >> 4)
+}
+
+// Decodes one BRR sample the way the S-DSP does. nibble is the signed 4-bit sample value,
+// and prev1 and prev2 are the previous two decoded 15-bit samples. Returns the new 15-bit
+// sample and whether the filter accumulation overflowed and wrapped around.
+//
+// The S-DSP accumulates to 17 bits, saturates to 16 bits, and then wraps to 15 bits.
+// Ranges 13-15 produce -2048 or 0.
+func decodeSampleHw(nibble int, brange int, filter int, prev1 int, prev2 int) (int, bool) {
+	var s int
+	if brange > 12 {
+		s = nibble &^ 0x07FF
+	} else {
+		s = (nibble << brange) >> 1
+	}
+
+	s = clamp(s+filterBase(prev1, prev2, filter), 16)
+	wrapped := int(int16(s<<1) >> 1)
+
+	return wrapped, wrapped != s
+}
+
+// Decodes one BRR block the way the S-DSP does, from the given previous two decoded
+// 15-bit samples. Returns the 15-bit samples, the new history, and a mask of the samples
+// that overflowed and wrapped around, with bit i set for sample i.
+func decodeBlockHw(block []byte, prev1 int, prev2 int) ([16]int, int, int, uint16) {
+	samples := [16]int{}
+	brange := int(block[0] >> 4)
+	filter := int(block[0]>>2) & 3
+	overflow := uint16(0)
+
+	for i := range samples {
+		s, wrapped := decodeSampleHw(blockNibble(block, i), brange, filter, prev1, prev2)
+		if wrapped {
+			overflow |= 1 << i
+		}
+		samples[i] = s
+		prev2 = prev1
+		prev1 = s
+	}
+
+	return samples, prev1, prev2, overflow
+}
@@ -0,0 +1,211 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+// S-DSP envelope generator.
+// https://problemkaputt.de/fullsnes.htm#snesapudspadsrgainenvelope
+
+package brr
+
+import "fmt"
+
+// Envelope settings for a voice, as written to the ADSR1, ADSR2 and GAIN registers.
+type Envelope struct {
+	// Use the ADSR envelope. Otherwise, the GAIN register is used.
+	ADSR bool
+
+	// Attack rate, 0-15. 15 reaches full volume almost immediately.
+	Attack int
+
+	// Decay rate, 0-7.
+	Decay int
+
+	// Sustain level, 0-7. Decay stops at (SustainLevel+1)/8 of full volume.
+	SustainLevel int
+
+	// Sustain rate, 0-31. 0 holds the sustain level until key off.
+	SustainRate int
+
+	// GAIN register value, used when ADSR is false. Values 0x00-0x7F set the envelope
+	// directly, and 0x80-0xFF select a decrease or increase mode and its rate.
+	Gain int
+}
+
+func (e Envelope) validate() error {
+	if e.ADSR {
+		if e.Attack < 0 || e.Attack > 15 {
+			return fmt.Errorf("%w: attack %d", ErrInvalidVoice, e.Attack)
+		}
+		if e.Decay < 0 || e.Decay > 7 {
+			return fmt.Errorf("%w: decay %d", ErrInvalidVoice, e.Decay)
+		}
+		if e.SustainLevel < 0 || e.SustainLevel > 7 {
+			return fmt.Errorf("%w: sustain level %d", ErrInvalidVoice, e.SustainLevel)
+		}
+		if e.SustainRate < 0 || e.SustainRate > 31 {
+			return fmt.Errorf("%w: sustain rate %d", ErrInvalidVoice, e.SustainRate)
+		}
+	} else if e.Gain < 0 || e.Gain > 0xFF {
+		return fmt.Errorf("%w: gain 0x%X", ErrInvalidVoice, e.Gain)
+	}
+	return nil
+}
+
+type envelopeMode int
+
+const (
+	envAttack envelopeMode = iota
+	envDecay
+	envSustain
+	envRelease
+)
+
+// Range of the global counter that the envelope rates are derived from.
+const kEnvCounterRange = 2048 * 5 * 3
+
+// Number of samples between envelope steps for each rate. Rate 0 never steps.
+var kEnvRates = [32]int{
+	kEnvCounterRange + 1, 2048, 1536,
+	1280, 1024, 768,
+	640, 512, 384,
+	320, 256, 192,
+	160, 128, 96,
+	80, 64, 48,
+	40, 32, 24,
+	20, 16, 12,
+	10, 8, 6,
+	5, 4, 3,
+	2, 1,
+}
+
+// Phase of each rate relative to the global counter.
+var kEnvOffsets = [32]int{
+	1, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	536, 0, 1040,
+	0, 0,
+}
+
+// Envelope generator state for a voice.
+type envelopeState struct {
+	settings Envelope
+	mode     envelopeMode
+
+	// Current envelope level, 0-0x7FF.
+	env int
+
+	// Unclamped level from the last step, used by the bent line GAIN mode.
+	hiddenEnv int
+
+	counter int
+}
+
+func (es *envelopeState) keyOn() {
+	es.mode = envAttack
+	es.env = 0
+	es.hiddenEnv = 0
+	es.counter = 0
+}
+
+// Returns true if the global counter allows a step at the given rate this sample.
+func (es *envelopeState) tick(rate int) bool {
+	return (es.counter+kEnvOffsets[rate])%kEnvRates[rate] == 0
+}
+
+// Advance the envelope by one sample.
+func (es *envelopeState) run() {
+	es.counter--
+	if es.counter < 0 {
+		es.counter = kEnvCounterRange - 1
+	}
+
+	env := es.env
+
+	if es.mode == envRelease {
+		env -= 8
+		if env < 0 {
+			env = 0
+		}
+		es.env = env
+		return
+	}
+
+	var rate int
+	sustainLevel := -1
+	settings := es.settings
+
+	if settings.ADSR {
+		sustainLevel = settings.SustainLevel
+		if es.mode == envAttack {
+			rate = settings.Attack*2 + 1
+			if rate < 31 {
+				env += 0x20
+			} else {
+				env += 0x400
+			}
+		} else {
+			env--
+			env -= env >> 8
+			rate = settings.SustainRate
+			if es.mode == envDecay {
+				rate = settings.Decay*2 + 16
+			}
+		}
+	} else {
+		mode := settings.Gain >> 5
+		if mode < 4 {
+			// Direct.
+			env = settings.Gain * 0x10
+			rate = 31
+		} else {
+			rate = settings.Gain & 0x1F
+			switch mode {
+			case 4:
+				// Linear decrease.
+				env -= 0x20
+			case 5:
+				// Exponential decrease.
+				env--
+				env -= env >> 8
+			case 6:
+				// Linear increase.
+				env += 0x20
+			case 7:
+				// Bent line increase, which slows down above 3/4 volume. A negative level
+				// left over from a decrease also counts as above.
+				if uint(es.hiddenEnv) >= 0x600 {
+					env += 0x8
+				} else {
+					env += 0x20
+				}
+			}
+		}
+	}
+
+	if env>>8 == sustainLevel && es.mode == envDecay {
+		es.mode = envSustain
+	}
+	es.hiddenEnv = env
+
+	if env < 0 || env > 0x7FF {
+		if env < 0 {
+			env = 0
+		} else {
+			env = 0x7FF
+		}
+		if es.mode == envAttack {
+			es.mode = envDecay
+		}
+	}
+
+	if es.tick(rate) {
+		es.env = env
+	}
+}
@@ -0,0 +1,96 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func runEnvelope(es *envelopeState, samples int) {
+	for i := 0; i < samples; i++ {
+		es.run()
+	}
+}
+
+func TestEnvelopeAdsr(t *testing.T) {
+	es := envelopeState{settings: Envelope{
+		ADSR:         true,
+		Attack:       15,
+		Decay:        7,
+		SustainLevel: 3,
+		SustainRate:  0,
+	}}
+	es.keyOn()
+
+	// Attack 15 adds 0x400 every sample.
+	runEnvelope(&es, 1)
+	assert.Equal(t, 0x400, es.env)
+	runEnvelope(&es, 1)
+	assert.Equal(t, 0x7FF, es.env)
+	assert.Equal(t, envDecay, es.mode)
+
+	// Decay 7 steps every 2 samples, until the level falls to sustain level 3. The mode
+	// changes on the sample in between, so the last step isn't stored.
+	runEnvelope(&es, 1000)
+	assert.Equal(t, envSustain, es.mode)
+	assert.InDelta(t, 0x400, es.env, 8)
+
+	// Sustain rate 0 holds the level.
+	level := es.env
+	runEnvelope(&es, 10000)
+	assert.Equal(t, level, es.env)
+
+	// Release subtracts 8 every sample.
+	es.mode = envRelease
+	runEnvelope(&es, 10)
+	assert.Equal(t, level-80, es.env)
+	runEnvelope(&es, 1000)
+	assert.Zero(t, es.env)
+}
+
+func TestEnvelopeGain(t *testing.T) {
+	// Direct.
+	es := envelopeState{settings: Envelope{Gain: 0x40}}
+	es.keyOn()
+	runEnvelope(&es, 1)
+	assert.Equal(t, 0x400, es.env)
+
+	// Linear increase at rate 31 adds 0x20 every sample.
+	es = envelopeState{settings: Envelope{Gain: 0xDF}}
+	es.keyOn()
+	runEnvelope(&es, 10)
+	assert.Equal(t, 0x140, es.env)
+	runEnvelope(&es, 100)
+	assert.Equal(t, 0x7FF, es.env)
+
+	// Bent line increase slows down to 8 per sample at 0x600.
+	es = envelopeState{settings: Envelope{Gain: 0xFF}}
+	es.keyOn()
+	runEnvelope(&es, 0x600/0x20+10)
+	assert.Equal(t, 0x600+10*8, es.env)
+
+	// Rate 0 never steps.
+	es = envelopeState{settings: Envelope{Gain: 0xC0}}
+	es.keyOn()
+	runEnvelope(&es, 100000)
+	assert.Zero(t, es.env)
+}
+
+func TestVoiceKeyOff(t *testing.T) {
+	codec := encodeForVoice(createSinePcm16(1600, 10000))
+
+	settings := DefaultVoiceSettings()
+	settings.KeyOff = 100
+	assert.NoError(t, codec.SetVoice(&settings))
+	codec.Decode()
+
+	// The envelope at 0x7F0 takes 254 samples to release.
+	assert.Len(t, codec.PcmData, 100+254)
+
+	settings.Envelope = Envelope{ADSR: true, Attack: 16}
+	assert.ErrorIs(t, codec.SetVoice(&settings), ErrInvalidVoice)
+}
@@ -0,0 +1,277 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+// S-DSP voice emulation, for hearing BRR data the way the SNES plays it.
+// https://problemkaputt.de/fullsnes.htm#snesapudspbrrpitch
+
+package brr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Returned when voice settings are out of range.
+var ErrInvalidVoice = errors.New("invalid voice settings")
+
+// Output rate of the S-DSP.
+const DspRate SampleRate = 32000
+
+// Settings for playing BRR data through an emulated S-DSP voice.
+type VoiceSettings struct {
+	// Pitch register value, 0x0001-0x3FFF. 0x1000 plays the sample at 32000 Hz.
+	Pitch int
+
+	// Voice volume register value, -128 to 127.
+	Volume int
+
+	// Envelope register values.
+	Envelope Envelope
+
+	// Output sample at which the voice is keyed off, counting from key on at DspRate, or
+	// 0 to hold the note. After key off, the envelope fades out over at most 256 samples.
+	KeyOff int
+}
+
+// Returns voice settings that play the sample at 32000 Hz and full volume, with a GAIN
+// envelope fixed at full volume.
+func DefaultVoiceSettings() VoiceSettings {
+	return VoiceSettings{
+		Pitch:    0x1000,
+		Volume:   0x7F,
+		Envelope: Envelope{Gain: 0x7F},
+	}
+}
+
+func (vs VoiceSettings) validate() error {
+	if vs.Pitch < 0x0001 || vs.Pitch > 0x3FFF {
+		return fmt.Errorf("%w: %w: 0x%X", ErrInvalidVoice, ErrBadPitch, vs.Pitch)
+	}
+	if vs.Volume < -128 || vs.Volume > 127 {
+		return fmt.Errorf("%w: volume %d", ErrInvalidVoice, vs.Volume)
+	}
+	if vs.KeyOff < 0 {
+		return fmt.Errorf("%w: key off %d", ErrInvalidVoice, vs.KeyOff)
+	}
+	return vs.Envelope.validate()
+}
+
+// An emulated S-DSP voice playing BRR data. Samples are decoded 4 at a time into a
+// 12-sample ring buffer, and the output is interpolated from the buffer with the
+// gaussian filter at the rate given by the pitch.
+type Voice struct {
+	settings   VoiceSettings
+	brrData    []byte
+	loopOffset int
+
+	// Offset of the current block, its header, and the next nibble to decode.
+	addr   int
+	header byte
+	nibble int
+
+	// Decoded samples, stored twice so that 4 samples can be read without wrapping.
+	ring    [24]int
+	ringPos int
+
+	// Previous two decoded 15-bit samples, for the BRR filters.
+	prev1 int
+	prev2 int
+
+	// Pitch counter. Bits 12-14 are the sample position in the ring, and bits 4-11 are
+	// the gaussian interpolation index.
+	interpPos int
+
+	// Remaining samples before playback starts after key on.
+	konDelay int
+
+	envelope envelopeState
+	active   bool
+	loops    int
+}
+
+// Create a voice for the given BRR data. loopOffset is the byte offset that the voice
+// jumps to after a block with the END and LOOP flags. The voice is silent until KeyOn.
+func NewVoice(brrData []byte, loopOffset int, settings VoiceSettings) (*Voice, error) {
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+
+	if loopOffset < 0 {
+		loopOffset = 0
+	}
+
+	return &Voice{
+		settings:   settings,
+		brrData:    brrData,
+		loopOffset: loopOffset,
+		envelope:   envelopeState{settings: settings.Envelope},
+	}, nil
+}
+
+// Start playing the sample from the beginning. Like the hardware, the first output
+// sample comes after a short delay.
+func (v *Voice) KeyOn() {
+	v.addr = 0
+	v.nibble = 0
+	v.ringPos = 0
+	v.prev1 = 0
+	v.prev2 = 0
+	v.interpPos = 0
+	v.loops = 0
+	v.active = true
+	v.header = v.readHeader()
+	v.envelope.keyOn()
+
+	// The hardware spends the delay filling the ring buffer with the first 12 samples.
+	v.konDelay = 5
+	for i := 0; i < 3; i++ {
+		v.decodeGroup()
+	}
+}
+
+// Release the note. The envelope fades out and the voice stops when it reaches zero.
+func (v *Voice) KeyOff() {
+	v.envelope.mode = envRelease
+}
+
+// Returns true while the voice is producing sound.
+func (v *Voice) Active() bool {
+	return v.active
+}
+
+// Returns how many times the voice has jumped back to the loop point.
+func (v *Voice) Loops() int {
+	return v.loops
+}
+
+// Returns the header of the current block. Reading past the end of the data acts like a
+// silent block with the END flag.
+func (v *Voice) readHeader() byte {
+	if v.addr+9 > len(v.brrData) {
+		return 0x01
+	}
+	return v.brrData[v.addr]
+}
+
+// Decode the next 4 samples into the ring buffer.
+func (v *Voice) decodeGroup() {
+	brange := int(v.header >> 4)
+	filter := int(v.header>>2) & 3
+
+	for i := 0; i < 4; i++ {
+		nibble := 0
+		if v.addr+9 <= len(v.brrData) {
+			nibble = blockNibble(v.brrData[v.addr:], v.nibble)
+		}
+
+		s, _ := decodeSampleHw(nibble, brange, filter, v.prev1, v.prev2)
+		v.prev2 = v.prev1
+		v.prev1 = s
+
+		v.ring[v.ringPos+i] = s << 1
+		v.ring[v.ringPos+i+12] = s << 1
+		v.nibble++
+	}
+
+	v.ringPos = (v.ringPos + 4) % 12
+
+	if v.nibble == 16 {
+		// Start the next block.
+		if v.header&0x01 != 0 {
+			v.addr = v.loopOffset
+			if v.header&0x02 != 0 {
+				v.loops++
+			}
+		} else {
+			v.addr += 9
+		}
+		v.nibble = 0
+		v.header = v.readHeader()
+	}
+}
+
+// Returns the gaussian interpolation of the ring buffer at the current position.
+func (v *Voice) interpolate() int {
+	offset := (v.interpPos >> 4) & 0xFF
+	in := v.ring[(v.interpPos>>12)+v.ringPos:]
+
+	// The first 3 steps wrap to 16 bits. The last step saturates.
+	out := (int(kGaussTable[255-offset]) * in[0]) >> 11
+	out += (int(kGaussTable[511-offset]) * in[1]) >> 11
+	out += (int(kGaussTable[256+offset]) * in[2]) >> 11
+	out = int(int16(out))
+	out += (int(kGaussTable[offset]) * in[3]) >> 11
+	return clamp(out, 16) &^ 1
+}
+
+// Produce the next output sample at 32000 Hz.
+func (v *Voice) Next() int16 {
+	if !v.active {
+		return 0
+	}
+
+	if v.konDelay > 0 {
+		v.konDelay--
+		return 0
+	}
+
+	// A block with END but not LOOP silences the voice as soon as it's reached. The
+	// voice also stops once it's released and the envelope runs out.
+	if v.header&0x03 == 0x01 || (v.envelope.mode == envRelease && v.envelope.env == 0) {
+		v.active = false
+		v.envelope.mode = envRelease
+		v.envelope.env = 0
+		return 0
+	}
+
+	out := v.interpolate()
+	out = ((out * v.envelope.env) >> 11) &^ 1
+	out = (out * v.settings.Volume) >> 7
+	v.envelope.run()
+
+	// Once the position passes the oldest 4 samples, they are replaced.
+	if v.interpPos >= 0x4000 {
+		v.decodeGroup()
+	}
+	v.interpPos = (v.interpPos & 0x3FFF) + v.settings.Pitch
+	if v.interpPos > 0x7FFF {
+		v.interpPos = 0x7FFF
+	}
+
+	return int16(clamp(out, 16))
+}
+
+// Plays the BRR data through a voice from key on until the voice stops, or until the
+// sample loops, rendering a single pass of it. With a playback length, the voice keeps
+// looping until the duration or loop count runs out. The voice is keyed off at the sample
+// given in the settings.
+func renderVoice(brrData []byte, loopOffset int, settings VoiceSettings,
+	p playback) ([]int16, error) {
+	voice, err := NewVoice(brrData, loopOffset, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	maxLoops := 0
+	if p.enabled() {
+		maxLoops = p.loops(math.MaxInt)
+	}
+	length := p.samples(DspRate)
+
+	voice.KeyOn()
+	output := []int16{}
+	for length == 0 || len(output) < length {
+		if settings.KeyOff > 0 && len(output) == settings.KeyOff {
+			voice.KeyOff()
+		}
+		s := voice.Next()
+		if !voice.Active() || voice.Loops() > maxLoops {
+			break
+		}
+		output = append(output, s)
+	}
+
+	return p.fit(output, DspRate), nil
+}
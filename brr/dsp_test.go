@@ -0,0 +1,66 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeForVoice(pcm []int16) *BrrCodec {
+	codec := NewCodec()
+	codec.PcmData = pcm
+	codec.Encode()
+	return codec
+}
+
+func TestVoicePlayback(t *testing.T) {
+	pcm := createSinePcm16(1600, 10000)
+	codec := encodeForVoice(pcm)
+
+	settings := DefaultVoiceSettings()
+	assert.NoError(t, codec.SetVoice(&settings))
+	codec.Decode()
+	assert.Equal(t, SampleRate(32000), codec.PcmRate)
+
+	// The key on delay and the ring buffer delay the output by 4 samples. Decoding runs
+	// 12 samples ahead of the output, and the voice is silenced on the sample after
+	// decoding reaches the last block, which has the END flag.
+	assert.Len(t, codec.PcmData, 5+1600-16-12+1)
+	for i := 0; i < 4; i++ {
+		assert.Zero(t, codec.PcmData[i])
+	}
+
+	for i := 4; i < len(codec.PcmData); i++ {
+		assert.InDelta(t, pcm[i-4], codec.PcmData[i], 250, "sample %d", i)
+	}
+}
+
+func TestVoicePitchAndVolume(t *testing.T) {
+	pcm := createSinePcm16(1600, 10000)
+	codec := encodeForVoice(pcm)
+
+	settings := DefaultVoiceSettings()
+	settings.Pitch = 0x2000
+	settings.Volume = -0x40
+	assert.NoError(t, codec.SetVoice(&settings))
+	codec.Decode()
+
+	// Double speed, half volume, inverted.
+	assert.InDelta(t, 5+(1600-16-12)/2, len(codec.PcmData), 2)
+	for i := 100; i < len(codec.PcmData)-10; i++ {
+		assert.InDelta(t, -float64(pcm[(i-5)*2+1])/2, codec.PcmData[i], 250, "sample %d", i)
+	}
+
+	settings.Pitch = 0x4000
+	assert.ErrorIs(t, codec.SetVoice(&settings), ErrInvalidVoice)
+	assert.ErrorIs(t, codec.SetVoice(&settings), ErrBadPitch)
+
+	// nil goes back to the codec's decoder.
+	assert.NoError(t, codec.SetVoice(nil))
+	codec.Decode()
+	assert.Len(t, codec.PcmData, 1600)
+}
@@ -0,0 +1,105 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Highest range that decodes normally. The hardware treats 13-15 differently.
+const kMaxValidRange = 12
+
+// A decoded BRR block, as listed by InspectBrr.
+type BlockInfo struct {
+	// Byte offset of the block in the BRR data.
+	Offset int
+
+	// The header fields: the range (shift), the filter, and the END and LOOP flags.
+	Range  int
+	Filter int
+	End    bool
+	Loop   bool
+
+	// The decoded 16-bit samples.
+	Samples [16]int16
+
+	// Largest absolute value of the decoded samples.
+	Peak int
+
+	// Bit i is set when the filter accumulation for sample i leaves the 15-bit range and
+	// wraps around on the S-DSP, decoding the block from the history before it.
+	OverflowMask uint16
+
+	// Problems found with the block.
+	Warnings []string
+}
+
+// Decodes the BRR data block by block with the noc decoder and lists the header fields,
+// samples and peak of each block, with warnings for invalid ranges, filter overflow and
+// END flags before the last block.
+func InspectBrr(brrData []byte) ([]BlockInfo, error) {
+	if len(brrData)%9 != 0 {
+		return nil, fmt.Errorf("%w: length %d is not a multiple of 9", ErrInvalidBrr,
+			len(brrData))
+	}
+
+	c := &nocCodec{}
+	blocks := []BlockInfo{}
+	prev1, prev2 := 0, 0
+
+	for offset := 0; offset < len(brrData); offset += 9 {
+		block := brrData[offset : offset+9]
+		info := BlockInfo{
+			Offset: offset,
+			Range:  int(block[0] >> 4),
+			Filter: int(block[0]>>2) & 3,
+			End:    block[0]&1 != 0,
+			Loop:   block[0]&2 != 0,
+		}
+		_, _, _, info.OverflowMask = decodeBlockHw(block, prev1, prev2)
+
+		var samples []int16
+		samples, prev1, prev2 = c.decodeBlock(block, prev1, prev2)
+		copy(info.Samples[:], samples)
+
+		for _, s := range samples {
+			if abs := absInt(int(s)); abs > info.Peak {
+				info.Peak = abs
+			}
+		}
+
+		if info.Range > kMaxValidRange {
+			info.Warnings = append(info.Warnings,
+				fmt.Sprintf("range %d is invalid and decodes differently on hardware",
+					info.Range))
+		}
+		if info.OverflowMask != 0 {
+			overflowed := []string{}
+			for i := 0; i < 16; i++ {
+				if info.OverflowMask&(1<<i) != 0 {
+					overflowed = append(overflowed, fmt.Sprint(i))
+				}
+			}
+			info.Warnings = append(info.Warnings,
+				fmt.Sprintf("filter overflow at samples %s", strings.Join(overflowed, ", ")))
+		}
+		if info.End && offset+9 < len(brrData) {
+			info.Warnings = append(info.Warnings,
+				"END flag before the last block, the rest isn't played")
+		}
+
+		blocks = append(blocks, info)
+	}
+
+	return blocks, nil
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
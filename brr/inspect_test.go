@@ -0,0 +1,50 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInspectBrr(t *testing.T) {
+	brrData := []byte{
+		// Range 12, filter 0: a flat level of 0x7000.
+		0xC0, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77,
+		// Filter 3 keeps rising from there and overflows.
+		0xCD, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77,
+		// Range 13 with both flags.
+		0xD3, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	blocks, err := InspectBrr(brrData)
+	assert.NoError(t, err)
+	assert.Len(t, blocks, 3)
+
+	assert.Equal(t, 12, blocks[0].Range)
+	assert.Equal(t, 0, blocks[0].Filter)
+	assert.Equal(t, 0x7000, blocks[0].Peak)
+	assert.Equal(t, int16(0x7000), blocks[0].Samples[15])
+	assert.Empty(t, blocks[0].Warnings)
+
+	assert.Equal(t, 3, blocks[1].Filter)
+	assert.Equal(t, 9, blocks[1].Offset)
+	assert.NotZero(t, blocks[1].OverflowMask)
+	assert.Equal(t, 0x7FFF, blocks[1].Peak)
+	assert.Contains(t, blocks[1].Warnings[0], "filter overflow at samples 0, 3, 5")
+
+	assert.True(t, blocks[2].End)
+	assert.True(t, blocks[2].Loop)
+	assert.Contains(t, blocks[2].Warnings[0], "range 13")
+
+	// An END flag in the middle.
+	brrData[0] |= 1
+	blocks, _ = InspectBrr(brrData)
+	assert.Contains(t, blocks[0].Warnings, "END flag before the last block, the rest isn't played")
+
+	_, err = InspectBrr(brrData[:10])
+	assert.ErrorIs(t, err, ErrInvalidBrr)
+}
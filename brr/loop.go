@@ -0,0 +1,324 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Returned when an unknown crossfade curve or a negative length is given.
+var ErrInvalidCrossfade = errors.New("invalid loop crossfade")
+
+// Returned when an unknown loop alignment strategy is given.
+var ErrInvalidLoopAlign = errors.New("invalid loop alignment")
+
+// Number of samples before the loop start that are compared against the end of the
+// sample when searching for loop points.
+const kLoopMatchWindow = 256
+
+// Shortest loop that the loop point search returns.
+const kMinLoopLength = 16
+
+// Aligned loop points are ranked as if their seam error was this much lower.
+const kAlignedLoopBias = 0.75
+
+// A possible loop start found by FindLoopPoints.
+type LoopCandidate struct {
+	// Loop start in PcmData, in samples. The loop runs to the end of the sample.
+	Start int
+
+	// How different the waveform leading into the loop start is from the end of the
+	// sample, which is what plays right before the jump. This is the RMS difference
+	// relative to the RMS of the end of the sample, so 0 is a perfect match and 1 is
+	// about as bad as silence.
+	Score float64
+
+	// Correlation between the end of the sample and the waveform leading into the loop
+	// start, -1 to 1.
+	Correlation float64
+
+	// The loop start and length are multiples of 16 samples after resampling, so the
+	// loop doesn't need to be unrolled when encoding.
+	Aligned bool
+}
+
+// Searches PcmData for loop start points that make a smooth seam with the end of the
+// sample, and returns up to count candidates, best first. Candidates are found at the
+// peaks of the correlation between the end of the sample and each earlier point, and
+// ranked by how well the waveforms match. Points that avoid unrolling the loop are
+// preferred. Pass the chosen Start to SetLoop.
+func (bc *BrrCodec) FindLoopPoints(count int) []LoopCandidate {
+	pcm := bc.PcmData
+	window := kLoopMatchWindow
+	if window > len(pcm)/2 {
+		window = len(pcm) / 2
+	}
+	last := len(pcm) - kMinLoopLength
+	if window == 0 || count <= 0 || last < window {
+		return []LoopCandidate{}
+	}
+
+	tail := pcm[len(pcm)-window:]
+	tailEnergy := 0.0
+	for _, s := range tail {
+		tailEnergy += float64(s) * float64(s)
+	}
+
+	// Correlation of the tail with the window leading into each possible start.
+	corr := make([]float64, last+1)
+	for start := window; start <= last; start++ {
+		corr[start] = correlate(tail, pcm[start-window:start], tailEnergy)
+	}
+
+	candidates := []LoopCandidate{}
+	seen := map[int]bool{}
+	add := func(start int) {
+		if start < window || start > last || seen[start] {
+			return
+		}
+		seen[start] = true
+		candidates = append(candidates, LoopCandidate{
+			Start:       start,
+			Score:       seamError(tail, pcm[start-window:start], tailEnergy),
+			Correlation: corr[start],
+			Aligned:     bc.loopAligned(start),
+		})
+	}
+
+	for start := window; start <= last; start++ {
+		if (start > window && corr[start] < corr[start-1]) ||
+			(start < last && corr[start] < corr[start+1]) {
+			continue
+		}
+
+		add(start)
+
+		// Also try the nearest aligned points around each peak.
+		for offset := 1; offset < 16; offset++ {
+			if bc.loopAligned(start - offset) {
+				add(start - offset)
+				break
+			}
+		}
+		for offset := 1; offset < 16; offset++ {
+			if bc.loopAligned(start + offset) {
+				add(start + offset)
+				break
+			}
+		}
+	}
+
+	rank := func(c LoopCandidate) float64 {
+		if c.Aligned {
+			return c.Score * kAlignedLoopBias
+		}
+		return c.Score
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return rank(candidates[i]) < rank(candidates[j])
+	})
+
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+	return candidates
+}
+
+// Returns true if encoding with the given loop start won't unroll the loop.
+func (bc *BrrCodec) loopAligned(start int) bool {
+	length := len(bc.PcmData)
+	if bc.targetRate > 0 && bc.PcmRate > 0 && bc.targetRate != bc.PcmRate {
+		start = resampledLength(start, bc.PcmRate, bc.targetRate)
+		length = resampledLength(length, bc.PcmRate, bc.targetRate)
+	}
+	return start%16 == 0 && length%16 == 0
+}
+
+// Normalized cross-correlation of two windows.
+func correlate(a []int16, b []int16, aEnergy float64) float64 {
+	sum := 0.0
+	bEnergy := 0.0
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+		bEnergy += float64(b[i]) * float64(b[i])
+	}
+
+	if aEnergy == 0 || bEnergy == 0 {
+		if aEnergy == bEnergy {
+			return 1
+		}
+		return 0
+	}
+	return sum / math.Sqrt(aEnergy*bEnergy)
+}
+
+// RMS difference of two windows, relative to the RMS of the first.
+func seamError(a []int16, b []int16, aEnergy float64) float64 {
+	sum := 0.0
+	for i := range a {
+		d := float64(a[i]) - float64(b[i])
+		sum += d * d
+	}
+
+	if aEnergy == 0 {
+		// Measure against full scale when the end of the sample is silent.
+		aEnergy = float64(len(a)) * 32768 * 32768
+	}
+	return math.Sqrt(sum / aEnergy)
+}
+
+type crossfade struct {
+	// Length in samples of the input, or 0 to disable.
+	length int
+	curve  string
+}
+
+func createCrossfade(length int, curve string) (crossfade, error) {
+	if curve != "linear" && curve != "equal-power" {
+		return crossfade{}, fmt.Errorf("%w: %s", ErrInvalidCrossfade, curve)
+	}
+	if length < 0 {
+		return crossfade{}, fmt.Errorf("%w: length %d", ErrInvalidCrossfade, length)
+	}
+	return crossfade{length: length, curve: curve}, nil
+}
+
+// Returns the gains of the outgoing and incoming material at t, 0-1.
+func (cf crossfade) gains(t float64) (float64, float64) {
+	if cf.curve == "equal-power" {
+		return math.Cos(t * math.Pi / 2), math.Sin(t * math.Pi / 2)
+	}
+	return 1 - t, t
+}
+
+// Crossfades the end of the loop into the material leading up to the loop start, in
+// place, so that the end of the sample flows into the loop start. The length is limited
+// by the material available on both sides of the loop start.
+func (cf crossfade) apply(pcm []int16, loopStart int, length int) {
+	if loopStart < 0 || loopStart >= len(pcm) {
+		return
+	}
+	if length > loopStart {
+		length = loopStart
+	}
+	if length > len(pcm)-loopStart {
+		length = len(pcm) - loopStart
+	}
+
+	tail := pcm[len(pcm)-length:]
+	lead := pcm[loopStart-length : loopStart]
+	for i := range tail {
+		out, in := cf.gains(float64(i+1) / float64(length))
+		s := math.Round(float64(tail[i])*out + float64(lead[i])*in)
+		tail[i] = int16(clamp(int(s), 16))
+	}
+}
+
+// Number of passes through the loop that are decoded while waiting for the filter
+// history at the loop point to settle.
+const kLoopSettlePasses = 8
+
+// Decoder history: the previous two decoded 15-bit samples.
+type brrHistory struct {
+	prev1 int
+	prev2 int
+}
+
+// Decodes the BRR data from the given offset to the end, starting with the given
+// history. Returns the 15-bit samples and the history at the end.
+func decodeFrom(brrData []byte, offset int, h brrHistory) ([]int, brrHistory) {
+	output := []int{}
+	for b := offset; b+9 <= len(brrData); b += 9 {
+		var samples [16]int
+		samples, h.prev1, h.prev2, _ = decodeBlockHw(brrData[b:b+9], h.prev1, h.prev2)
+		output = append(output, samples[:]...)
+	}
+	return output, h
+}
+
+// Returns the filter history when the loop block is first reached, and the histories
+// that it's re-entered with on the following passes, until they settle. The loop block
+// is re-entered with the history left by the last block, which differs from the first
+// pass unless the loop block uses filter 0.
+func loopHistories(brrData []byte, loopOffset int) (brrHistory, []brrHistory) {
+	_, entry := decodeFrom(brrData[:loopOffset], 0, brrHistory{})
+
+	reentry := []brrHistory{}
+	h := entry
+	for pass := 0; pass < kLoopSettlePasses; pass++ {
+		_, next := decodeFrom(brrData, loopOffset, h)
+		if pass > 0 && next == h {
+			break
+		}
+		reentry = append(reentry, next)
+		h = next
+	}
+
+	return entry, reentry
+}
+
+// Returns the largest difference, in 16-bit PCM units, between the first pass through
+// the loop and the following passes. A difference means that the loop doesn't sound the
+// same each time around.
+func loopMismatch(brrData []byte, loopOffset int) int {
+	if loopOffset < 0 || loopOffset%9 != 0 || loopOffset >= len(brrData) {
+		return 0
+	}
+
+	entry, reentry := loopHistories(brrData, loopOffset)
+	first, _ := decodeFrom(brrData, loopOffset, entry)
+
+	mismatch := 0
+	for _, h := range reentry {
+		again, _ := decodeFrom(brrData, loopOffset, h)
+		for i := range first {
+			d := (first[i] - again[i]) << 1
+			if d < 0 {
+				d = -d
+			}
+			if d > mismatch {
+				mismatch = d
+			}
+		}
+	}
+
+	return mismatch
+}
+
+// Aligns the loop to BRR blocks without unrolling it. The loop is resampled to the
+// nearest multiple of 16 samples. The loop start is then moved forward to the next block
+// boundary, and the samples it skips are played once more at the end of the loop, so the
+// loop plays the same and the start of the sample isn't moved. Returns the new PCM data,
+// the loop start, and the pitch change of the loop in cents.
+func alignLoopByResampling(pcm []int16, loopStart int, r resampler) ([]int16, int,
+	float64) {
+
+	if loopStart < 0 || loopStart >= len(pcm) {
+		return pcm, loopStart, 0
+	}
+
+	loop := pcm[loopStart:]
+	length := (len(loop) + 8) / 16 * 16
+	if length == 0 {
+		length = 16
+	}
+	pitchCents := 0.0
+	if length != len(loop) {
+		loop = r.resampleLoop(loop, length)
+		pitchCents = 1200 * math.Log2(float64(len(pcm)-loopStart)/float64(length))
+	}
+
+	shift := (16 - loopStart%16) % 16
+	output := make([]int16, 0, loopStart+length+shift)
+	output = append(output, pcm[:loopStart]...)
+	output = append(output, loop...)
+	output = append(output, loop[:shift]...)
+
+	return output, loopStart + shift, pitchCents
+}
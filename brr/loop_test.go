@@ -0,0 +1,140 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createPeriodicPcm16(length int, period float64) []int16 {
+	pcm := make([]int16, length)
+	for i := range pcm {
+		phase := 2 * math.Pi * float64(i) / period
+		pcm[i] = int16(8000*math.Sin(phase) + 3000*math.Sin(3*phase+1))
+	}
+	return pcm
+}
+
+func TestFindLoopPoints(t *testing.T) {
+	codec := NewCodec()
+
+	// The seam is smooth when the loop starts a whole number of periods before the end.
+	codec.PcmData = createPeriodicPcm16(1550, 100)
+	candidates := codec.FindLoopPoints(5)
+	assert.Len(t, candidates, 5)
+	assert.Equal(t, 50, candidates[0].Start%100)
+	assert.Less(t, candidates[0].Score, 0.01)
+	assert.Greater(t, candidates[0].Correlation, 0.99)
+	assert.False(t, candidates[0].Aligned)
+	for i := 1; i < len(candidates); i++ {
+		assert.LessOrEqual(t, candidates[i-1].Score, candidates[i].Score)
+	}
+
+	// Aligned points are preferred when the seam is just as good.
+	codec.PcmData = createPeriodicPcm16(1600, 64)
+	candidates = codec.FindLoopPoints(1)
+	assert.True(t, candidates[0].Aligned)
+	assert.Zero(t, candidates[0].Start%64)
+
+	// Resampling changes which points are aligned.
+	codec.PcmRate = 16000
+	codec.SetTargetRate(32000)
+	assert.True(t, codec.loopAligned(8))
+	assert.False(t, codec.loopAligned(12))
+
+	codec.PcmData = []int16{1, 2, 3}
+	assert.Empty(t, codec.FindLoopPoints(5))
+}
+
+func TestLoopCrossfade(t *testing.T) {
+	pcm := make([]int16, 400)
+	for i := range pcm {
+		pcm[i] = int16(i * 10)
+	}
+
+	for _, curve := range []string{"linear", "equal-power"} {
+		cf, err := createCrossfade(50, curve)
+		assert.NoError(t, err)
+
+		faded := append([]int16{}, pcm...)
+		cf.apply(faded, 100, cf.length)
+
+		// The end of the sample now leads into the loop start.
+		assert.Equal(t, pcm[99], faded[399])
+		assert.InDelta(t, pcm[350], faded[350], 200)
+		assert.Equal(t, pcm[:350], faded[:350])
+	}
+
+	// The length is limited by the material before the loop start.
+	cf, _ := createCrossfade(50, "linear")
+	faded := append([]int16{}, pcm...)
+	cf.apply(faded, 10, cf.length)
+	assert.Equal(t, pcm[:390], faded[:390])
+	assert.Equal(t, pcm[9], faded[399])
+
+	_, err := createCrossfade(50, "cubic")
+	assert.ErrorIs(t, err, ErrInvalidCrossfade)
+	_, err = createCrossfade(-1, "linear")
+	assert.ErrorIs(t, err, ErrInvalidCrossfade)
+}
+
+func TestEncodeLoopCrossfade(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createPeriodicPcm16(1600, 100)
+	codec.SetLoop(830)
+	assert.NoError(t, codec.SetLoopCrossfade(200, "equal-power"))
+	pcmData, loopStart, _ := codec.prepareEncode()
+
+	// The tail matches the material before the loop start, and the input is untouched.
+	assert.Equal(t, 830, loopStart)
+	assert.Equal(t, pcmData[829], pcmData[1599])
+	assert.NotEqual(t, codec.PcmData[829], codec.PcmData[1599])
+}
+
+func TestAlignLoopByResampling(t *testing.T) {
+	pcm := createPeriodicPcm16(1000, 797.0/8)
+	r, _ := createResampler("sinc", 0)
+
+	aligned, loopStart, pitchCents := alignLoopByResampling(pcm, 203, r)
+	assert.Equal(t, 208, loopStart)
+	assert.Len(t, aligned, 203+800+5)
+	assert.InDelta(t, 1200*math.Log2(797.0/800), pitchCents, 0.001)
+
+	// The start of the sample isn't moved, and the samples that the loop start skips
+	// are repeated at the end of the loop.
+	assert.Equal(t, pcm[:203], aligned[:203])
+	assert.Equal(t, aligned[203:208], aligned[1003:1008])
+
+	// The loop is still smooth across the seam.
+	step := math.Abs(float64(aligned[len(aligned)-1]) - float64(aligned[loopStart]))
+	assert.Less(t, step, 700.0)
+
+	// Aligned loops are left alone.
+	pcm = pcm[:992]
+	aligned, loopStart, pitchCents = alignLoopByResampling(pcm, 32, r)
+	assert.Equal(t, pcm, aligned)
+	assert.Equal(t, 32, loopStart)
+	assert.Zero(t, pitchCents)
+}
+
+func TestEncodeLoopAlign(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createPeriodicPcm16(1000, 100)
+	codec.SetLoop(203)
+	result := codec.Encode()
+	assert.Greater(t, result.UnrolledSamples, 1000)
+
+	assert.NoError(t, codec.SetLoopAlign("resample"))
+	result = codec.Encode()
+	assert.Zero(t, result.UnrolledSamples)
+	assert.Equal(t, 13, result.LoopBlock)
+	assert.Len(t, codec.BrrData, (203+800+5)/16*9)
+	assert.Less(t, result.LoopPitchCents, 0.0)
+
+	assert.ErrorIs(t, codec.SetLoopAlign("stretch"), ErrInvalidLoopAlign)
+}
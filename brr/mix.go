@@ -0,0 +1,180 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Returned when an invalid channel mix or channel index is specified.
+var ErrInvalidChannelMix = errors.New("invalid channel mix")
+
+// How multichannel wav input is reduced to the single channel used by the BRR format.
+type mixMode int
+
+const (
+	// Average all channels together. This is the default.
+	mixAverage mixMode = iota
+
+	// Use only the first (left) channel.
+	mixLeft
+
+	// Use only the second (right) channel. Mono input uses the first channel.
+	mixRight
+
+	// Mid signal of the left and right channels, (L+R)/2. Other channels are ignored.
+	mixMid
+
+	// Side signal of the left and right channels, (L-R)/2. Other channels are ignored.
+	mixSide
+
+	// Use custom per-channel weights. See SetChannelWeights.
+	mixWeights
+)
+
+// If the downmixed signal is weaker than the channels it was made from by this ratio,
+// the channels are likely cancelling each other out.
+const kPhaseCancellationRatio = 0.5
+
+// Collects information about the last channel downmix performed when reading a wav.
+type DownmixStats struct {
+	// Number of channels in the source wav.
+	Channels int
+
+	// RMS level of the contributing source channels, averaged by their weights.
+	InputRms float64
+
+	// RMS level of the downmixed output.
+	OutputRms float64
+
+	// True if the output is significantly quieter than the input channels, which
+	// happens when the channels are out of phase with each other. Not checked for
+	// mixSide, where cancellation is the point.
+	PhaseCancellation bool
+}
+
+// Parses a mix mode name. Accepted names are "average", "left", "right", "mid", and
+// "side".
+func parseMixMode(name string) (mixMode, error) {
+	switch strings.ToLower(name) {
+	case "average":
+		return mixAverage, nil
+	case "left":
+		return mixLeft, nil
+	case "right":
+		return mixRight, nil
+	case "mid":
+		return mixMid, nil
+	case "side":
+		return mixSide, nil
+	}
+	return 0, fmt.Errorf("%w: %s", ErrInvalidChannelMix, name)
+}
+
+// Parses a comma separated weight list such as "0.7,0.3".
+func parseMixWeights(list string) ([]float64, error) {
+	weights := []float64{}
+	for _, field := range strings.Split(list, ",") {
+		w, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || math.IsNaN(w) || math.IsInf(w, 0) {
+			return nil, fmt.Errorf("%w: bad weight %q", ErrInvalidChannelMix, field)
+		}
+		weights = append(weights, w)
+	}
+	return weights, nil
+}
+
+// Returns the weight applied to each channel for the given mode. Weights for channels
+// that don't exist are dropped, and missing weights are treated as 0.
+func mixWeightsFor(mode mixMode, weights []float64, channels int) ([]float64, error) {
+	w := make([]float64, channels)
+	right := 1
+	if channels < 2 {
+		right = 0
+	}
+
+	switch mode {
+	case mixAverage:
+		for i := range w {
+			w[i] = 1.0 / float64(channels)
+		}
+	case mixLeft:
+		w[0] = 1
+	case mixRight:
+		w[right] = 1
+	case mixMid:
+		w[0] += 0.5
+		w[right] += 0.5
+	case mixSide:
+		w[0] += 0.5
+		w[right] -= 0.5
+	case mixWeights:
+		if len(weights) == 0 {
+			return nil, fmt.Errorf("%w: no channel weights", ErrInvalidChannelMix)
+		}
+		for ch := channels; ch < len(weights); ch++ {
+			if weights[ch] != 0 {
+				return nil, fmt.Errorf("%w: channel %d does not exist in %d-channel input",
+					ErrInvalidChannelMix, ch, channels)
+			}
+		}
+		copy(w, weights)
+	default:
+		return nil, ErrInvalidChannelMix
+	}
+
+	return w, nil
+}
+
+// Mixes interleaved 16-bit frames down to one channel with the given weights.
+func downmix(interleaved []int16, weights []float64) ([]int16, DownmixStats) {
+	channels := len(weights)
+	frames := len(interleaved) / channels
+	output := make([]int16, frames)
+	stats := DownmixStats{Channels: channels}
+
+	channelEnergy := make([]float64, channels)
+	outputEnergy := 0.0
+
+	for f := 0; f < frames; f++ {
+		frame := interleaved[f*channels : f*channels+channels]
+		mixed := 0.0
+		for ch, w := range weights {
+			s := float64(frame[ch])
+			mixed += s * w
+			channelEnergy[ch] += s * s
+		}
+
+		mixed = math.Round(mixed)
+		if mixed > 32767 {
+			mixed = 32767
+		} else if mixed < -32768 {
+			mixed = -32768
+		}
+		output[f] = int16(mixed)
+		outputEnergy += mixed * mixed
+	}
+
+	if frames == 0 {
+		return output, stats
+	}
+
+	weightSum := 0.0
+	for ch, w := range weights {
+		w = math.Abs(w)
+		stats.InputRms += w * math.Sqrt(channelEnergy[ch]/float64(frames))
+		weightSum += w
+	}
+	if weightSum > 0 {
+		stats.InputRms /= weightSum
+	}
+	stats.OutputRms = math.Sqrt(outputEnergy / float64(frames))
+
+	return output, stats
+}
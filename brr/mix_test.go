@@ -0,0 +1,94 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
+	"github.com/stretchr/testify/assert"
+)
+
+func createStereoWavFile(filename string, left []int, right []int) {
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	pcm := make([]int, 0, len(left)*2)
+	for i := range left {
+		pcm = append(pcm, left[i], right[i])
+	}
+
+	encoder := wav.NewEncoder(f, 32000, 16, 2, 1)
+	defer encoder.Close()
+
+	err = encoder.Write(&audio.IntBuffer{
+		Data:           pcm,
+		Format:         &audio.Format{NumChannels: 2, SampleRate: 32000},
+		SourceBitDepth: 16,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestChannelMix(t *testing.T) {
+	defer os.Remove(".testfile_mix.wav")
+	createStereoWavFile(".testfile_mix.wav", []int{1000, 2000, -400}, []int{3000, -2000, 400})
+
+	read := func(mix string) []int16 {
+		codec := NewCodec()
+		assert.NoError(t, codec.SetChannelMix(mix))
+		assert.NoError(t, codec.ReadWavFile(".testfile_mix.wav"))
+		return codec.PcmData
+	}
+
+	assert.Equal(t, []int16{2000, 0, 0}, read("average"))
+	assert.Equal(t, []int16{1000, 2000, -400}, read("left"))
+	assert.Equal(t, []int16{3000, -2000, 400}, read("right"))
+	assert.Equal(t, []int16{2000, 0, 0}, read("mid"))
+	assert.Equal(t, []int16{-1000, 2000, -400}, read("side"))
+	assert.Equal(t, []int16{1500, 1000, -200}, read("0.75,0.25"))
+
+	// Selecting a channel that doesn't exist is an error.
+	codec := NewCodec()
+	assert.NoError(t, codec.SetChannel(2))
+	assert.ErrorIs(t, codec.ReadWavFile(".testfile_mix.wav"), ErrInvalidChannelMix)
+
+	assert.ErrorIs(t, codec.SetChannelMix("loud"), ErrInvalidChannelMix)
+}
+
+func TestPhaseCancellation(t *testing.T) {
+	defer os.Remove(".testfile_mix.wav")
+
+	sine := createSinePcm16(1000, 10000)
+	left := make([]int, len(sine))
+	inverted := make([]int, len(sine))
+	for i, s := range sine {
+		left[i] = int(s)
+		inverted[i] = -int(s)
+	}
+
+	// Inverted channels cancel out completely when averaged.
+	createStereoWavFile(".testfile_mix.wav", left, inverted)
+	codec := NewCodec()
+	assert.NoError(t, codec.ReadWavFile(".testfile_mix.wav"))
+	assert.True(t, codec.DownmixStats().PhaseCancellation)
+	assert.Equal(t, 2, codec.DownmixStats().Channels)
+
+	// The side signal is expected to be full strength here.
+	codec.SetChannelMix("side")
+	assert.NoError(t, codec.ReadWavFile(".testfile_mix.wav"))
+	assert.False(t, codec.DownmixStats().PhaseCancellation)
+
+	createStereoWavFile(".testfile_mix.wav", left, left)
+	codec = NewCodec()
+	assert.NoError(t, codec.ReadWavFile(".testfile_mix.wav"))
+	assert.False(t, codec.DownmixStats().PhaseCancellation)
+}
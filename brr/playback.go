@@ -0,0 +1,104 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import "math"
+
+// How long Decode plays the BRR data for. By default, a single pass is decoded.
+type playback struct {
+	// Length of the output in seconds, or 0 to stop when the sample ends.
+	seconds float64
+
+	// Number of times to jump back to the loop point, or -1 to loop until the duration
+	// runs out.
+	loopCount int
+}
+
+// Returns true if Decode should follow the loop instead of decoding a single pass.
+func (p playback) enabled() bool {
+	return p.seconds > 0 || p.loopCount >= 0
+}
+
+// Returns the number of loops to play when the given number would be enough to fill the
+// duration.
+func (p playback) loops(toFillDuration int) int {
+	if p.loopCount >= 0 && (p.seconds == 0 || p.loopCount < toFillDuration) {
+		return p.loopCount
+	}
+	return toFillDuration
+}
+
+// Returns the number of output samples for the duration at the given rate, or 0 if no
+// duration is set.
+func (p playback) samples(rate SampleRate) int {
+	return int(math.Round(p.seconds * float64(rate)))
+}
+
+// Cuts or pads the output with silence to the duration, if one is set.
+func (p playback) fit(pcm []int16, rate SampleRate) []int16 {
+	if p.seconds == 0 {
+		return pcm
+	}
+
+	length := p.samples(rate)
+	if len(pcm) > length {
+		return pcm[:length]
+	}
+	return append(pcm, make([]int16, length-len(pcm))...)
+}
+
+// Returns the BRR data with the loop copied after it the given number of times, so that
+// decoding it straight through plays the loop. The filter history carries over into each
+// copy the same way it does when the hardware jumps back to the loop point. Returns the
+// data unchanged if it doesn't loop.
+func unrollBrr(brrData []byte, loopOffset int, loops int) []byte {
+	if len(brrData) < 9 || len(brrData)%9 != 0 || brrData[len(brrData)-9]&0x03 != 0x03 {
+		return brrData
+	}
+
+	if loopOffset < 0 || loopOffset%9 != 0 || loopOffset >= len(brrData) {
+		loopOffset = 0
+	}
+
+	loop := brrData[loopOffset:]
+	output := make([]byte, 0, len(brrData)+len(loop)*loops)
+	output = append(output, brrData...)
+	for i := 0; i < loops; i++ {
+		// Only the final copy ends the sample.
+		output[len(output)-9] &^= 0x01
+		output = append(output, loop...)
+	}
+
+	return output
+}
+
+// Decodes the BRR data with the codec, following the loop for the playback length.
+func decodePlayback(codec codecImpl, brrData []byte, loopOffset int, p playback) ([]int16,
+	SampleRate) {
+	pcm, rate := codec.Decode(brrData)
+
+	unrolled := unrollBrr(brrData, loopOffset, 1)
+	if len(unrolled) == len(brrData) {
+		return p.fit(pcm, rate), rate
+	}
+
+	// Find how many loops fill the duration from the length of a single pass.
+	toFill := 0
+	if p.seconds > 0 {
+		loopBlocks := (len(unrolled) - len(brrData)) / 9
+		samplesPerBlock := float64(len(pcm)) / float64(len(brrData)/9)
+		remaining := float64(p.samples(rate) - len(pcm))
+		if remaining > 0 {
+			toFill = int(math.Ceil(remaining/(samplesPerBlock*float64(loopBlocks)))) + 1
+		}
+	}
+
+	loops := p.loops(toFill)
+	if loops > 0 {
+		pcm, rate = codec.Decode(unrollBrr(brrData, loopOffset, loops))
+	}
+
+	return p.fit(pcm, rate), rate
+}
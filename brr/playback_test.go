@@ -0,0 +1,68 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnrollBrr(t *testing.T) {
+	brrData := []byte{
+		0xB0, 1, 2, 3, 4, 5, 6, 7, 8,
+		0xB2, 1, 1, 1, 1, 1, 1, 1, 1,
+		0xB7, 2, 2, 2, 2, 2, 2, 2, 2,
+	}
+
+	unrolled := unrollBrr(brrData, 9, 2)
+	assert.Len(t, unrolled, 9*7)
+	assert.Equal(t, byte(0xB6), unrolled[18])
+	assert.Equal(t, byte(0xB2), unrolled[27])
+	assert.Equal(t, byte(0xB6), unrolled[36])
+	assert.Equal(t, byte(0xB7), unrolled[54])
+
+	// The original data is left alone.
+	assert.Equal(t, byte(0xB7), brrData[18])
+
+	// No LOOP flag, no loop.
+	brrData[18] = 0xB1
+	assert.Equal(t, brrData, unrollBrr(brrData, 9, 2))
+}
+
+func TestLoopPlayback(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createSinePcm16(1600, 10000)
+	codec.SetLoop(800)
+	codec.Encode()
+
+	codec.SetLoopCount(2)
+	codec.Decode()
+	assert.Len(t, codec.PcmData, 1600+800*2)
+
+	// The loop repeats the same waveform.
+	for i := 1600; i < 2400; i++ {
+		assert.InDelta(t, codec.PcmData[i-800], codec.PcmData[i], 200)
+	}
+
+	codec.SetLoopCount(-1)
+	codec.SetPlayDuration(0.5)
+	codec.Decode()
+	assert.Len(t, codec.PcmData, 16000)
+
+	// The voice follows the loop in the same way.
+	settings := DefaultVoiceSettings()
+	codec.SetVoice(&settings)
+	codec.Decode()
+	assert.Len(t, codec.PcmData, 16000)
+	assert.NotZero(t, codec.PcmData[15999])
+
+	// Without a loop, the duration is padded with silence.
+	codec.SetLoop(-1)
+	codec.Encode()
+	codec.Decode()
+	assert.Len(t, codec.PcmData, 16000)
+	assert.Zero(t, codec.PcmData[15999])
+}
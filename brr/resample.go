@@ -0,0 +1,157 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Returned when an unknown resampler or an invalid resampling quality is specified.
+var ErrInvalidResampler = errors.New("invalid resampler")
+
+// Default number of sinc zero crossings on each side of the resampling kernel.
+const kDefaultResampleQuality = 16
+
+// Highest accepted resampling quality.
+const kMaxResampleQuality = 64
+
+type resampler struct {
+	method  string
+	quality int
+}
+
+func createResampler(method string, quality int) (resampler, error) {
+	if method != "sinc" && method != "linear" {
+		return resampler{}, fmt.Errorf("%w: %s", ErrInvalidResampler, method)
+	}
+
+	if quality == 0 {
+		quality = kDefaultResampleQuality
+	}
+
+	if quality < 1 || quality > kMaxResampleQuality {
+		return resampler{}, fmt.Errorf("%w: quality must be 1-%d", ErrInvalidResampler,
+			kMaxResampleQuality)
+	}
+
+	return resampler{method: method, quality: quality}, nil
+}
+
+// Returns the number of samples produced when resampling the given length. This is also
+// used to convert sample positions such as the loop start.
+func resampledLength(length int, fromRate SampleRate, toRate SampleRate) int {
+	return int(math.Round(float64(length) * float64(toRate) / float64(fromRate)))
+}
+
+// Resample the PCM data between the given rates.
+func (r resampler) resample(pcm []int16, fromRate SampleRate, toRate SampleRate) []int16 {
+	return r.resampleTo(pcm, resampledLength(len(pcm), fromRate, toRate))
+}
+
+// Resample the PCM data so that it has the given length.
+func (r resampler) resampleTo(pcm []int16, length int) []int16 {
+	output := make([]int16, length)
+	if len(pcm) == 0 || length == 0 {
+		return output
+	}
+
+	step := float64(len(pcm)) / float64(length)
+
+	for i := range output {
+		pos := float64(i) * step
+		var s float64
+		if r.method == "linear" {
+			s = linearSample(pcm, pos)
+		} else {
+			s = sincSample(pcm, pos, step, r.quality)
+		}
+
+		s = math.Round(s)
+		if s > 32767 {
+			s = 32767
+		} else if s < -32768 {
+			s = -32768
+		}
+		output[i] = int16(s)
+	}
+
+	return output
+}
+
+func linearSample(pcm []int16, pos float64) float64 {
+	i := int(pos)
+	frac := pos - float64(i)
+	a := float64(pcm[i])
+	b := a
+	if i+1 < len(pcm) {
+		b = float64(pcm[i+1])
+	}
+	return a + (b-a)*frac
+}
+
+// Windowed-sinc interpolation at the given input position. When downsampling (step > 1)
+// the cutoff is lowered to the new Nyquist frequency to avoid aliasing. Samples outside
+// of the input are treated as silence.
+func sincSample(pcm []int16, pos float64, step float64, zeroCrossings int) float64 {
+	cutoff := 1.0
+	if step > 1 {
+		cutoff = 1.0 / step
+	}
+
+	halfWidth := float64(zeroCrossings) / cutoff
+	first := int(math.Ceil(pos - halfWidth))
+	last := int(math.Floor(pos + halfWidth))
+	if first < 0 {
+		first = 0
+	}
+	if last > len(pcm)-1 {
+		last = len(pcm) - 1
+	}
+
+	sum := 0.0
+	for j := first; j <= last; j++ {
+		t := float64(j) - pos
+		sum += float64(pcm[j]) * cutoff * sinc(cutoff*t) * blackman(t/halfWidth)
+	}
+	return sum
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+// Blackman window over [-1, 1].
+func blackman(x float64) float64 {
+	if x <= -1 || x >= 1 {
+		return 0
+	}
+	phase := math.Pi * (x + 1)
+	return 0.42 - 0.5*math.Cos(phase) + 0.08*math.Cos(2*phase)
+}
+
+// Resample a loop so that it has the given length. The loop is treated as repeating, so
+// the interpolation wraps around the ends and the seam stays smooth.
+func (r resampler) resampleLoop(loop []int16, length int) []int16 {
+	if len(loop) == 0 || length == 0 {
+		return make([]int16, length)
+	}
+
+	// Surround the loop with enough copies of itself to cover the filter kernel.
+	context := (r.quality*2 + len(loop) - 1) / len(loop)
+	copies := context*2 + 1
+	tiled := make([]int16, 0, len(loop)*copies)
+	for i := 0; i < copies; i++ {
+		tiled = append(tiled, loop...)
+	}
+
+	resampled := r.resampleTo(tiled, length*copies)
+	return resampled[length*context : length*(context+1)]
+}
@@ -0,0 +1,68 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResampleLength(t *testing.T) {
+	for _, method := range []string{"sinc", "linear"} {
+		r, err := createResampler(method, 0)
+		assert.NoError(t, err)
+
+		assert.Len(t, r.resample(make([]int16, 44100), 44100, 32000), 32000)
+		assert.Len(t, r.resample(make([]int16, 1000), 16000, 32000), 2000)
+		assert.Len(t, r.resample([]int16{}, 16000, 32000), 0)
+	}
+
+	_, err := createResampler("cubic", 0)
+	assert.ErrorIs(t, err, ErrInvalidResampler)
+	_, err = createResampler("sinc", 65)
+	assert.ErrorIs(t, err, ErrInvalidResampler)
+}
+
+func TestResampleSine(t *testing.T) {
+	// A 1000 Hz sine at 44100 Hz resampled to 32000 Hz should still be a 1000 Hz sine.
+	input := make([]int16, 44100)
+	for i := range input {
+		input[i] = int16(10000 * math.Sin(2*math.Pi*1000*float64(i)/44100))
+	}
+
+	for _, method := range []string{"sinc", "linear"} {
+		r, _ := createResampler(method, 0)
+		output := r.resample(input, 44100, 32000)
+
+		// Skip the edges, where the sinc kernel runs out of input.
+		maxError := 0.0
+		for i := 1000; i < len(output)-1000; i++ {
+			expected := 10000 * math.Sin(2*math.Pi*1000*float64(i)/32000)
+			maxError = math.Max(maxError, math.Abs(expected-float64(output[i])))
+		}
+
+		if method == "sinc" {
+			assert.Less(t, maxError, 20.0)
+		} else {
+			assert.Less(t, maxError, 300.0)
+		}
+	}
+}
+
+func TestEncodeTargetRate(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createSinePcm16(4410, 10000)
+	codec.PcmRate = 44100
+	codec.SetTargetRate(32000)
+	codec.Encode()
+
+	// 3200 samples = 200 blocks.
+	assert.Len(t, codec.BrrData, 200*9)
+
+	// The original PCM data is left alone.
+	assert.Len(t, codec.PcmData, 4410)
+}
@@ -0,0 +1,277 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"math"
+	"sort"
+)
+
+// Shortest stream, in blocks, that ScanBrr returns by default.
+const kDefaultScanMinBlocks = 16
+
+// Longest stream, in blocks, that ScanBrr considers. A sample has to fit in ARAM.
+const kScanMaxBlocks = kAramSize / 9
+
+// Streams quieter than this RMS level, in 16-bit PCM units, are taken to be empty
+// memory rather than samples.
+const kScanMinRms = 16
+
+// Streams louder than this RMS level are taken to be random data, which decodes to noise
+// that's pinned near full scale by the filters.
+const kScanMaxRms = 16384
+
+// Blocks before the best scoring start of a stream are only included in it when their
+// noise, 1 minus their smoothness, is at most this many times the noise of as many
+// blocks that follow them, plus kScanLeadNoiseFloor. Random data that the filters
+// smooth out is still much noisier than a sample, while the attack of a sample is
+// usually only somewhat noisier than what follows.
+const kScanLeadNoise = 4
+const kScanLeadNoiseFloor = 0.01
+
+// A possible BRR stream found by ScanBrr.
+type ScanHit struct {
+	// Byte offset of the first block in the scanned data.
+	Offset int
+
+	// Size of the stream in bytes, up to and including the block with the end flag.
+	Size int
+
+	// The last block has the loop flag. The loop offset isn't part of the BRR data, so
+	// it can't be found by scanning.
+	Looped bool
+
+	// How much the decoded stream looks like audio, 0-1. This is mostly its smoothness:
+	// random data decodes to white noise and scores near 0, while real samples are
+	// dominated by lower frequencies and score near 1. Samples that overflow while
+	// decoding are penalized.
+	Score float64
+}
+
+// Scans arbitrary binary data, such as a ROM image, for plausible BRR streams. A stream
+// must have valid ranges (0-12) in every block, end with a block that has the end flag,
+// start with a filter 0 block that isn't silent, be at least minBlocks long (pass 0 for
+// 16), and decode to a level that isn't silent or saturated, with no more than half of
+// its blocks silent. Each stream is decoded and scored. Of the streams that share an
+// end, the one with the best score is found, and then earlier starts are added back
+// while the blocks that they add sound like the blocks that follow them, so that
+// unrelated data before a sample isn't included but a noisier attack is. Where streams
+// overlap, the one with the best score is kept. Returns the hits with a score of at least
+// minScore, ordered by offset.
+func ScanBrr(data []byte, minBlocks int, minScore float64) []ScanHit {
+	if minBlocks <= 0 {
+		minBlocks = kDefaultScanMinBlocks
+	}
+
+	// Number of blocks in the chain starting at each offset, up to the end block, or 0
+	// when the chain runs into an invalid range or the end of the data.
+	blocks := make([]int32, len(data)+9)
+	for offset := len(data) - 9; offset >= 0; offset-- {
+		header := data[offset]
+		switch {
+		case header>>4 > 12:
+		case header&1 != 0:
+			blocks[offset] = 1
+		case blocks[offset+9] > 0 && blocks[offset+9] < kScanMaxBlocks:
+			blocks[offset] = blocks[offset+9] + 1
+		}
+	}
+
+	// The possible starts of each chain, by end offset, in order.
+	starts := map[int][]int{}
+	for offset := 0; offset+9 <= len(data); offset++ {
+		count := int(blocks[offset])
+		if count < minBlocks || data[offset]&0x0C != 0 {
+			continue
+		}
+		end := offset + count*9
+		starts[end] = append(starts[end], offset)
+	}
+
+	candidates := []ScanHit{}
+	for end, offsets := range starts {
+		stream := decodeScanStream(data[offsets[0]:end])
+		index := func(i int) int {
+			return (offsets[i] - offsets[0]) / 9
+		}
+
+		// Start from the tail with the best score.
+		first := -1
+		bestScore := 0.0
+		for i := range offsets {
+			if score, ok := stream.score(index(i), stream.blocks); ok &&
+				(first < 0 || score > bestScore) {
+				first, bestScore = i, score
+			}
+		}
+		if first < 0 {
+			continue
+		}
+
+		// A tail can score better than the whole sample, so earlier starts are added
+		// back while the blocks that they add sound like the blocks that follow them.
+		for first > 0 {
+			from, to := index(first-1), index(first)
+			next := to + to - from
+			if next > stream.blocks {
+				next = stream.blocks
+			}
+			follow := stream.smoothness(to, next)
+			lead := stream.leadSmoothness(from, to)
+			if _, ok := stream.score(from, stream.blocks); !ok ||
+				1-lead > kScanLeadNoise*(1-follow)+kScanLeadNoiseFloor {
+				break
+			}
+			first--
+		}
+
+		score, _ := stream.score(index(first), stream.blocks)
+		if score >= minScore {
+			candidates = append(candidates, ScanHit{
+				Offset: offsets[first],
+				Size:   end - offsets[first],
+				Looped: data[end-9]&2 != 0,
+				Score:  score,
+			})
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Offset < candidates[j].Offset
+	})
+
+	hits := []ScanHit{}
+	for _, cand := range candidates {
+		overlaps := false
+		for _, hit := range hits {
+			if cand.Offset < hit.Offset+hit.Size && hit.Offset < cand.Offset+cand.Size {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			hits = append(hits, cand)
+		}
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		return hits[i].Offset < hits[j].Offset
+	})
+	return hits
+}
+
+// A decoded stream, with running sums of each block's measurements so that any range of
+// blocks can be scored. A block with filter 0 doesn't depend on the history, so a range
+// that starts with one decodes the same as it does in the whole stream.
+type scanStream struct {
+	blocks int
+
+	// Sums of the energy, the energy of the differences between samples, the blocks
+	// that overflow, and the blocks that are silent, over the blocks before each index.
+	energy     []float64
+	diffEnergy []float64
+	overflows  []int
+	silent     []int
+
+	// Energy of the step into the first sample of each block, which isn't part of a
+	// range that starts there.
+	step []float64
+}
+
+func decodeScanStream(brrData []byte) *scanStream {
+	blocks := len(brrData) / 9
+	stream := &scanStream{
+		blocks:     blocks,
+		energy:     make([]float64, blocks+1),
+		diffEnergy: make([]float64, blocks+1),
+		overflows:  make([]int, blocks+1),
+		silent:     make([]int, blocks+1),
+		step:       make([]float64, blocks),
+	}
+
+	prev1, prev2 := 0, 0
+	last := 0
+	for b := 0; b < blocks; b++ {
+		var samples [16]int
+		var overflow uint16
+		samples, prev1, prev2, overflow = decodeBlockHw(brrData[b*9:b*9+9], prev1, prev2)
+
+		energy, diffEnergy := 0.0, 0.0
+		for i, s := range samples {
+			s <<= 1
+			energy += float64(s) * float64(s)
+			d := float64(s - last)
+			diffEnergy += d * d
+			if i == 0 {
+				stream.step[b] = d * d
+			}
+			last = s
+		}
+
+		stream.energy[b+1] = stream.energy[b] + energy
+		stream.diffEnergy[b+1] = stream.diffEnergy[b] + diffEnergy
+		stream.overflows[b+1] = stream.overflows[b]
+		if overflow != 0 {
+			stream.overflows[b+1]++
+		}
+		stream.silent[b+1] = stream.silent[b]
+		if samples == [16]int{} {
+			stream.silent[b+1]++
+		}
+	}
+
+	return stream
+}
+
+// Rates how much the blocks from one index up to another look like audio, 0-1. This is
+// mostly their smoothness: random data decodes to white noise and scores near 0, while
+// real samples are dominated by lower frequencies and score near 1. Blocks that overflow
+// are penalized.
+func (s *scanStream) smoothness(from int, to int) float64 {
+	energy := s.energy[to] - s.energy[from]
+	if energy == 0 {
+		return 0
+	}
+	diffEnergy := s.diffEnergy[to] - s.diffEnergy[from] - s.step[from]
+
+	// White noise has twice as much energy in its differences as in the signal.
+	smoothness := 1 - diffEnergy/(2*energy)
+	if smoothness < 0 {
+		smoothness = 0
+	}
+
+	length := to - from
+	overflows := s.overflows[to] - s.overflows[from]
+	return smoothness * (1 - float64(overflows)/float64(length))
+}
+
+// Returns the smoothness of the blocks from one index up to another, including the step
+// into the block after them, for rating blocks that lead into a stream.
+func (s *scanStream) leadSmoothness(from int, to int) float64 {
+	energy := s.energy[to] - s.energy[from]
+	if energy == 0 {
+		return 0
+	}
+	diffEnergy := s.diffEnergy[to] - s.diffEnergy[from] - s.step[from] + s.step[to]
+	return math.Max(0, 1-diffEnergy/(2*energy))
+}
+
+// Returns the smoothness of the blocks from one index up to another, or false if they
+// don't look like a sample: if their level is out of range, or if they start with a
+// silent block or are mostly silent, which is empty memory that runs into something with
+// an end flag.
+func (s *scanStream) score(from int, to int) (float64, bool) {
+	length := to - from
+	rms := math.Sqrt((s.energy[to] - s.energy[from]) / float64(length*16))
+	silent := s.silent[to] - s.silent[from]
+	if rms < kScanMinRms || rms > kScanMaxRms || s.silent[from+1] != s.silent[from] ||
+		silent*2 > length {
+		return 0, false
+	}
+	return s.smoothness(from, to), true
+}
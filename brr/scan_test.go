@@ -0,0 +1,47 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanBrr(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createPeriodicPcm16(1600, 100)
+	codec.SetLoop(800)
+	codec.Encode()
+	looped := codec.BrrData
+
+	codec.PcmData = createSinePcm16(3200, 12000)
+	codec.SetLoop(-1)
+	codec.Encode()
+	oneShot := codec.BrrData
+
+	// Samples embedded in random data, with a stretch of empty memory. The random data
+	// right before a sample can look like more blocks of it, so several seeds are tried.
+	for seed := int64(1); seed <= 50; seed++ {
+		random := rand.New(rand.NewSource(seed))
+		data := make([]byte, 0x10000)
+		random.Read(data)
+		copy(data[1001:], looped)
+		copy(data[30000:], oneShot)
+		for i := 50000; i < 60000; i++ {
+			data[i] = 0
+		}
+
+		hits := ScanBrr(data, 0, 0.5)
+		if !assert.Len(t, hits, 2, "seed %d", seed) {
+			continue
+		}
+		assert.Equal(t, []ScanHit{
+			{Offset: 1001, Size: len(looped), Looped: true, Score: hits[0].Score},
+			{Offset: 30000, Size: len(oneShot), Score: hits[1].Score},
+		}, hits, "seed %d", seed)
+	}
+}
@@ -0,0 +1,154 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Label used for source output when none is set and there's no file name to use.
+const kDefaultSourceLabel = "brr_sample"
+
+// Package of Go source output when none is set.
+const kDefaultSourcePackage = "brrdata"
+
+// Number of bytes written on each line of source output.
+const kSourceBytesPerLine = 16
+
+// Returns true if the BRR file format is written as source text.
+func isSourceFormat(format string) bool {
+	switch format {
+	case "ca65", "wla", "asar", "c", "go":
+		return true
+	}
+	return false
+}
+
+// Sets the label used for the data in source output formats. The length and loop offset
+// constants are named after it. Characters that can't be used in a symbol are replaced
+// with underscores. When empty (the default), WriteBrrFile uses the file name.
+func (bc *BrrCodec) SetSourceLabel(label string) {
+	bc.sourceLabel = label
+}
+
+// Sets the package name of Go source output. Characters that can't be used in a package
+// name are replaced with underscores. When empty (the default), "brrdata" is used.
+func (bc *BrrCodec) SetSourcePackage(name string) {
+	bc.sourcePackage = name
+}
+
+// Returns the package name for Go source output.
+func (bc *BrrCodec) packageName() string {
+	if bc.sourcePackage == "" {
+		return kDefaultSourcePackage
+	}
+	return symbolName(bc.sourcePackage)
+}
+
+// Returns the label as an exported Go identifier: the first letter is capitalized, and
+// labels that start with an underscore, which symbolName adds before a digit, are
+// prefixed with "Brr".
+func exportedName(label string) string {
+	if label == "" || label[0] == '_' {
+		label = "Brr" + label
+	}
+	return strings.ToUpper(label[:1]) + label[1:]
+}
+
+// Returns the label for source output, falling back to the given file name.
+func (bc *BrrCodec) labelFor(filename string) string {
+	label := bc.sourceLabel
+	if label == "" && filename != "" {
+		label = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	}
+	if label == "" {
+		label = kDefaultSourceLabel
+	}
+	return symbolName(label)
+}
+
+// Writes the BRR data as source text in the given format, with the label, a length
+// constant and a loop offset constant. The loop offset is 0 when there is no loop. Go
+// source is written in package pkg, with the label exported.
+func writeBrrSource(w io.Writer, format string, label string, pkg string, data []byte,
+	loopOffset int) error {
+
+	if loopOffset < 0 {
+		loopOffset = 0
+	}
+
+	lines := []string{}
+	add := func(format string, args ...any) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	// Formats each line of bytes with the given prefix, byte format and separator.
+	addBytes := func(prefix string, byteFormat string, sep string, trailing string) {
+		for i := 0; i < len(data); i += kSourceBytesPerLine {
+			end := i + kSourceBytesPerLine
+			if end > len(data) {
+				end = len(data)
+			}
+			values := []string{}
+			for _, b := range data[i:end] {
+				values = append(values, fmt.Sprintf(byteFormat, b))
+			}
+			add("%s%s%s", prefix, strings.Join(values, sep), trailing)
+		}
+	}
+
+	switch format {
+	case "ca65":
+		add("; Generated by snesbrr.")
+		add("%s_LENGTH = %d", label, len(data))
+		add("%s_LOOP = %d", label, loopOffset)
+		add("")
+		add("%s:", label)
+		addBytes("\t.byte ", "$%02X", ",", "")
+	case "wla":
+		add("; Generated by snesbrr.")
+		add(".DEFINE %s_LENGTH %d", label, len(data))
+		add(".DEFINE %s_LOOP %d", label, loopOffset)
+		add("")
+		add("%s:", label)
+		addBytes("\t.DB ", "$%02X", ",", "")
+	case "asar":
+		add("; Generated by snesbrr.")
+		add("!%s_LENGTH = %d", label, len(data))
+		add("!%s_LOOP = %d", label, loopOffset)
+		add("")
+		add("%s:", label)
+		addBytes("\tdb ", "$%02X", ",", "")
+	case "c":
+		upper := strings.ToUpper(label)
+		add("// Generated by snesbrr.")
+		add("#define %s_LENGTH %d", upper, len(data))
+		add("#define %s_LOOP %d", upper, loopOffset)
+		add("")
+		add("static const unsigned char %s[%s_LENGTH] = {", label, upper)
+		addBytes("\t", "0x%02X", ", ", ",")
+		add("};")
+	case "go":
+		name := exportedName(label)
+		add("// Code generated by snesbrr. DO NOT EDIT.")
+		add("")
+		add("package %s", pkg)
+		add("")
+		add("const %sLength = %d", name, len(data))
+		add("const %sLoop = %d", name, loopOffset)
+		add("")
+		add("var %s = []byte{", name)
+		addBytes("\t", "0x%02X", ", ", ",")
+		add("}")
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
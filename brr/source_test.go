@@ -0,0 +1,62 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteBrrSource(t *testing.T) {
+	codec := NewCodec()
+	codec.BrrData = make([]byte, 18)
+	codec.BrrData[0] = 0xB0
+	codec.BrrData[9] = 0x03
+	codec.LoopOffset = 9
+
+	expected := map[string][]string{
+		"ca65": {"piano_LENGTH = 18\n", "piano_LOOP = 9\n", "piano:\n\t.byte $B0,$00,"},
+		"wla":  {".DEFINE piano_LENGTH 18\n", ".DEFINE piano_LOOP 9\n", "piano:\n\t.DB $B0,"},
+		"asar": {"!piano_LENGTH = 18\n", "!piano_LOOP = 9\n", "piano:\n\tdb $B0,"},
+		"c": {"#define PIANO_LENGTH 18\n", "#define PIANO_LOOP 9\n",
+			"piano[PIANO_LENGTH] = {\n\t0xB0, 0x00,", "0x00,\n\t0x00, 0x00,\n};\n"},
+		"go": {"package brrdata\n", "const PianoLength = 18\n", "const PianoLoop = 9\n",
+			"var Piano = []byte{\n\t0xB0, 0x00,"},
+	}
+
+	for format, parts := range expected {
+		assert.NoError(t, codec.SetBrrFormat(format))
+		codec.SetSourceLabel("piano")
+
+		var output bytes.Buffer
+		assert.NoError(t, codec.WriteBrr(&output))
+		for _, part := range parts {
+			assert.Contains(t, output.String(), part, format)
+		}
+
+		assert.ErrorIs(t, codec.ReadBrr(&output), ErrUnknownFormat, format)
+	}
+
+	// Without a loop, the loop offset is 0. The label falls back to a default.
+	codec.LoopOffset = -1
+	codec.SetSourceLabel("")
+	codec.SetBrrFormat("ca65")
+	var output bytes.Buffer
+	assert.NoError(t, codec.WriteBrr(&output))
+	assert.Contains(t, output.String(), "brr_sample_LOOP = 0\n")
+
+	assert.Equal(t, "snare_2", codec.labelFor("samples/snare-2.s"))
+
+	// Go output is exported, in the package that's set.
+	codec.SetBrrFormat("go")
+	codec.SetSourceLabel("2nd")
+	codec.SetSourcePackage("sounds")
+	output.Reset()
+	assert.NoError(t, codec.WriteBrr(&output))
+	assert.Contains(t, output.String(), "package sounds\n")
+	assert.Contains(t, output.String(), "var Brr_2nd = []byte{")
+}
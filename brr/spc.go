@@ -0,0 +1,215 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Returned when an SPC file is too short or doesn't have the SPC signature.
+var ErrInvalidSpc = errors.New("invalid spc file")
+
+// Signature at the start of an SPC file.
+const kSpcSignature = "SNES-SPC700 Sound File Data"
+
+// Offsets of the sections of an SPC file.
+const (
+	kSpcRamOffset = 0x100
+	kSpcDspOffset = 0x10100
+	kSpcMinLength = 0x10180
+)
+
+// Number of invalid directory entries in a row after which Samples stops reading the
+// directory.
+const kSpcMaxInvalidEntries = 16
+
+// DSP register numbers used to find samples.
+const (
+	kDspDir  = 0x5D
+	kDspSrcn = 0x04
+)
+
+// An SPC file: a snapshot of the SPC700 sound system.
+type SpcFile struct {
+	// SPC700 registers.
+	PC  int
+	A   int
+	X   int
+	Y   int
+	PSW int
+	SP  int
+
+	// Song and game titles from the ID666 tag, if there is one.
+	SongTitle string
+	GameTitle string
+
+	// Contents of ARAM.
+	Ram []byte
+
+	// DSP registers.
+	Dsp []byte
+}
+
+// A sample found in an SPC file.
+type SpcSample struct {
+	// Index in the sample directory (SRCN).
+	Index int
+
+	// ARAM address of the first block.
+	Start int
+
+	// Byte offset of the loop block within BrrData, or -1 if there is no loop.
+	LoopOffset int
+
+	BrrData []byte
+}
+
+// Reads an SPC file from a stream.
+func ReadSpc(r io.Reader) (*SpcFile, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(kSpcSignature)) {
+		return nil, fmt.Errorf("%w: missing signature", ErrInvalidSpc)
+	}
+	if len(data) < kSpcMinLength {
+		return nil, fmt.Errorf("%w: %d bytes is too short", ErrInvalidSpc, len(data))
+	}
+
+	spc := &SpcFile{
+		PC:  int(data[0x25]) | int(data[0x26])<<8,
+		A:   int(data[0x27]),
+		X:   int(data[0x28]),
+		Y:   int(data[0x29]),
+		PSW: int(data[0x2A]),
+		SP:  int(data[0x2B]),
+		Ram: data[kSpcRamOffset : kSpcRamOffset+kAramSize],
+		Dsp: data[kSpcDspOffset : kSpcDspOffset+128],
+	}
+
+	// Byte 0x23 is 26 when the header has an ID666 tag.
+	if data[0x23] == 26 {
+		spc.SongTitle = id666String(data[0x2E:0x4E])
+		spc.GameTitle = id666String(data[0x4E:0x6E])
+	}
+
+	return spc, nil
+}
+
+// Reads an SPC file.
+func ReadSpcFile(filename string) (*SpcFile, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadSpc(file)
+}
+
+// Returns a text field of an ID666 tag, which is padded with zeros.
+func id666String(field []byte) string {
+	if end := bytes.IndexByte(field, 0); end >= 0 {
+		field = field[:end]
+	}
+	return strings.TrimSpace(string(field))
+}
+
+// Returns the ARAM address of the sample directory, from the DSP's DIR register.
+func (spc *SpcFile) Dir() int {
+	return int(spc.Dsp[kDspDir]) << 8
+}
+
+// Reads the sample at the given directory index. Returns false if the entry doesn't
+// point to valid BRR data: the data must end with an end block before the end of ARAM,
+// use only ranges 0-12, and the loop address must be the start of one of its blocks.
+func (spc *SpcFile) sample(index int) (SpcSample, bool) {
+	entry := (spc.Dir() + index*kDirEntrySize) & 0xFFFF
+	read16 := func(address int) int {
+		return int(spc.Ram[address&0xFFFF]) | int(spc.Ram[(address+1)&0xFFFF])<<8
+	}
+	start := read16(entry)
+	loop := read16(entry + 2)
+
+	end := -1
+	for address := start; address+9 <= kAramSize; address += 9 {
+		if spc.Ram[address]>>4 > 12 {
+			return SpcSample{}, false
+		}
+		if spc.Ram[address]&1 != 0 {
+			end = address + 9
+			break
+		}
+	}
+	if end < 0 {
+		return SpcSample{}, false
+	}
+
+	sample := SpcSample{
+		Index:      index,
+		Start:      start,
+		LoopOffset: -1,
+		BrrData:    bytes.Clone(spc.Ram[start:end]),
+	}
+
+	if spc.Ram[end-9]&2 != 0 {
+		sample.LoopOffset = loop - start
+		if loop < start || loop >= end || sample.LoopOffset%9 != 0 {
+			return SpcSample{}, false
+		}
+	}
+
+	return sample, true
+}
+
+// Returns the samples in the sample directory. The size of the directory isn't stored,
+// so entries are read in order, skipping ones that don't point to valid BRR data, until
+// 16 in a row are invalid. The samples that the voices are set to are included even if
+// they come after that. Entries with the same start and loop addresses are the same
+// sample, which is listed once, by its lowest index.
+func (spc *SpcFile) Samples() []SpcSample {
+	type location struct {
+		start int
+		loop  int
+	}
+	samples := []SpcSample{}
+	seen := map[location]bool{}
+	add := func(sample SpcSample) {
+		key := location{sample.Start, sample.LoopOffset}
+		if !seen[key] {
+			seen[key] = true
+			samples = append(samples, sample)
+		}
+	}
+
+	last := 0
+	for invalid := 0; last < kDirEntries && invalid < kSpcMaxInvalidEntries; last++ {
+		sample, ok := spc.sample(last)
+		if !ok {
+			invalid++
+			continue
+		}
+		invalid = 0
+		add(sample)
+	}
+
+	for voice := 0; voice < 8; voice++ {
+		srcn := int(spc.Dsp[voice<<4|kDspSrcn])
+		if srcn < last {
+			continue
+		}
+		if sample, ok := spc.sample(srcn); ok {
+			add(sample)
+		}
+	}
+
+	return samples
+}
@@ -0,0 +1,77 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns an SPC file with a sample directory at $0200. Entries 0 and 1 are samples,
+// entries 2 and 3 aren't, entry 4 is the sample of entry 0 with a different loop, entry 5
+// is the same as entry 0, and voice 3 plays entry $20, past the end of the directory.
+func createTestSpc() []byte {
+	data := make([]byte, 0x10200)
+	copy(data, kSpcSignature+" v0.30")
+	data[0x23] = 26
+	copy(data[0x2E:], "Song")
+	copy(data[0x4E:], "Game")
+
+	ram := data[kSpcRamOffset:]
+	dsp := data[kSpcDspOffset:]
+	dsp[kDspDir] = 0x02
+	dsp[0x30|kDspSrcn] = 0x20
+
+	dir := []byte{
+		0x00, 0x03, 0x09, 0x03,
+		0x00, 0x04, 0x00, 0x04,
+		0x00, 0x05, 0x00, 0x05,
+		0x00, 0x00, 0x00, 0x00,
+		0x00, 0x03, 0x12, 0x03,
+		0x00, 0x03, 0x09, 0x03,
+	}
+	copy(ram[0x200:], dir)
+	copy(ram[0x280:], []byte{0x00, 0x06, 0x00, 0x06})
+
+	// Empty entries point at an invalid range.
+	ram[0x000] = 0xF0
+	ram[0x300] = 0xB0
+	ram[0x309] = 0xB4
+	ram[0x312] = 0xB7
+	ram[0x400] = 0xC1
+	ram[0x500] = 0xF1
+	ram[0x600] = 0x01
+
+	return data
+}
+
+func TestReadSpc(t *testing.T) {
+	spc, err := ReadSpc(bytes.NewReader(createTestSpc()))
+	assert.NoError(t, err)
+	assert.Equal(t, "Song", spc.SongTitle)
+	assert.Equal(t, "Game", spc.GameTitle)
+	assert.Equal(t, 0x200, spc.Dir())
+
+	samples := spc.Samples()
+	assert.Len(t, samples, 4)
+	assert.Equal(t, SpcSample{Index: 0, Start: 0x300, LoopOffset: 9,
+		BrrData: spc.Ram[0x300:0x31B]}, samples[0])
+	assert.Equal(t, 1, samples[1].Index)
+	assert.Equal(t, -1, samples[1].LoopOffset)
+	assert.Len(t, samples[1].BrrData, 9)
+
+	// Invalid entries are skipped, and a sample is only listed again with another loop.
+	assert.Equal(t, 4, samples[2].Index)
+	assert.Equal(t, 18, samples[2].LoopOffset)
+	assert.Equal(t, 0x20, samples[3].Index)
+
+	_, err = ReadSpc(bytes.NewReader(createTestSpc()[:0x10000]))
+	assert.ErrorIs(t, err, ErrInvalidSpc)
+
+	_, err = ReadSpc(bytes.NewReader(make([]byte, 0x10200)))
+	assert.ErrorIs(t, err, ErrInvalidSpc)
+}
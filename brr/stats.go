@@ -0,0 +1,72 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import "math"
+
+// Measures BRR data against the PCM data it was encoded from. pcmData is the input after
+// loop alignment and unrolling. Samples past the end of pcmData are compared against
+// silence. Errors are measured in 16-bit PCM units, using the hardware decoding.
+func measureEncoding(pcmData []int16, brrData []byte) EncodingStats {
+	stats := EncodingStats{}
+	signal := 0.0
+	prev1 := 0
+	prev2 := 0
+
+	for b := 0; b+9 <= len(brrData); b += 9 {
+		header := brrData[b]
+		brange := int(header >> 4)
+		filter := int(header>>2) & 3
+		stats.RangeHistogram[brange]++
+		stats.FilterHistogram[filter]++
+
+		blockError := 0.0
+		for i := 0; i < 16; i++ {
+			desired := 0
+			if index := b/9*16 + i; index < len(pcmData) {
+				desired = int(pcmData[index])
+			}
+
+			nibble := blockNibble(brrData[b:], i)
+
+			if brange <= 12 {
+				// Check if the target was out of reach of the range.
+				delta := desired>>1 - filterBase(prev1, prev2, filter)
+				halfStep := (1 << brange) >> 2
+				if delta < ((-8<<brange)>>1)-halfStep || delta > ((7<<brange)>>1)+halfStep {
+					stats.ClippedSamples++
+				}
+			}
+
+			decoded, _ := decodeSampleHw(nibble, brange, filter, prev1, prev2)
+			prev2 = prev1
+			prev1 = decoded
+
+			e := float64(desired - decoded<<1)
+			stats.PeakError = math.Max(stats.PeakError, math.Abs(e))
+			blockError += e * e
+			signal += float64(desired) * float64(desired)
+		}
+
+		if stats.Blocks == 0 || blockError < stats.MinError {
+			stats.MinError = blockError
+		}
+		stats.MaxError = math.Max(stats.MaxError, blockError)
+		stats.TotalError += blockError
+		stats.Blocks++
+	}
+
+	if stats.Blocks > 0 {
+		stats.AvgError = stats.TotalError / float64(stats.Blocks)
+	}
+
+	if stats.TotalError == 0 {
+		stats.SNR = math.Inf(1)
+	} else {
+		stats.SNR = 10 * math.Log10(signal/stats.TotalError)
+	}
+
+	return stats
+}
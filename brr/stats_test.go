@@ -0,0 +1,67 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodingStats(t *testing.T) {
+	for _, impl := range []string{"noc", "dmv"} {
+		codec := NewCodec()
+		codec.SetCodecImplementation(impl)
+		codec.PcmData = createSinePcm16(1600, 20000)
+		codec.Encode()
+
+		stats := codec.EncodingStats()
+		assert.Equal(t, 100, stats.Blocks, impl)
+		assert.Greater(t, stats.SNR, 30.0, impl)
+		assert.Greater(t, stats.TotalError, 0.0, impl)
+		assert.InDelta(t, stats.TotalError/100, stats.AvgError, 1e-9, impl)
+		assert.LessOrEqual(t, stats.MinError, stats.AvgError, impl)
+		assert.GreaterOrEqual(t, stats.MaxError, stats.AvgError, impl)
+		assert.Greater(t, stats.PeakError, 0.0, impl)
+
+		filters := 0
+		for _, n := range stats.FilterHistogram {
+			filters += n
+		}
+		ranges := 0
+		for _, n := range stats.RangeHistogram {
+			ranges += n
+		}
+		assert.Equal(t, 100, filters, impl)
+		assert.Equal(t, 100, ranges, impl)
+
+		// The first block can't use a filter.
+		assert.GreaterOrEqual(t, stats.FilterHistogram[0], 1, impl)
+	}
+}
+
+func TestEncodingStatsLossless(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createLosslessPcm(100)
+	codec.Encode()
+
+	stats := codec.EncodingStats()
+	assert.Equal(t, 0.0, stats.TotalError)
+	assert.Equal(t, 0.0, stats.PeakError)
+	assert.True(t, math.IsInf(stats.SNR, 1))
+}
+
+func TestEncodingStatsClipping(t *testing.T) {
+	// The first block can only use filter 0, which can't reach full scale.
+	codec := NewCodec()
+	codec.PcmData = make([]int16, 16)
+	for i := 8; i < 16; i++ {
+		codec.PcmData[i] = 32767
+	}
+	codec.Encode()
+
+	assert.Equal(t, 8, codec.EncodingStats().ClippedSamples)
+}
@@ -0,0 +1,235 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"fmt"
+)
+
+// How serious a Diagnostic is.
+type Severity string
+
+const (
+	// The problem stops the sample from playing as intended.
+	SeverityError Severity = "error"
+
+	// The problem is likely to be audible or depends on hardware quirks.
+	SeverityWarning Severity = "warning"
+)
+
+// What kind of problem a Diagnostic is.
+type DiagnosticKind string
+
+const (
+	// The last block doesn't have the END flag (error).
+	KindMissingEnd DiagnosticKind = "missing-end"
+
+	// The loop offset isn't the start of a block in the data (error).
+	KindInvalidLoop DiagnosticKind = "invalid-loop"
+
+	// A loop offset is given, but the last block doesn't have the LOOP flag, so the
+	// sample stops at the end instead of looping.
+	KindIgnoredLoop DiagnosticKind = "ignored-loop"
+
+	// A block before the last one has the END flag, so the rest is never played.
+	KindStrayEnd DiagnosticKind = "stray-end"
+
+	// A block other than the last one has the LOOP flag, which is ignored.
+	KindStrayLoop DiagnosticKind = "stray-loop"
+
+	// The block uses range 13-15.
+	KindInvalidRange DiagnosticKind = "invalid-range"
+
+	// The first block uses a filter, so it depends on the history left by whatever
+	// played before it.
+	KindFirstFilter DiagnosticKind = "first-filter"
+
+	// The filter accumulation left the 15-bit range and wrapped around to the opposite
+	// sign.
+	KindWraparound DiagnosticKind = "wraparound"
+
+	// Three samples in a row are so close to full scale that the gaussian interpolation
+	// overflows at some pitches and inverts the sign.
+	KindGaussianOverflow DiagnosticKind = "gaussian-overflow"
+)
+
+// A problem found by ValidateBrr.
+type Diagnostic struct {
+	Severity Severity
+	Kind     DiagnosticKind
+
+	// Index and byte offset of the block, or -1 when it applies to the whole stream.
+	Block  int
+	Offset int
+
+	// Sample within the block, 0-15, or -1 when it applies to the whole block.
+	Sample int
+
+	// True when the problem only happens on a later pass through the loop, when the
+	// loop block is decoded with the history left by the end of the sample.
+	Looped bool
+
+	Message string
+}
+
+// Returns the diagnostic as text for listing.
+func (d Diagnostic) String() string {
+	location := "stream"
+	if d.Block >= 0 {
+		location = fmt.Sprintf("block %d ($%04X)", d.Block, d.Offset)
+	}
+	if d.Sample >= 0 {
+		location += fmt.Sprintf(" sample %d", d.Sample)
+	}
+	if d.Looped {
+		location += " (looped)"
+	}
+	return fmt.Sprintf("%s: %s: %s [%s]", d.Severity, location, d.Message, d.Kind)
+}
+
+// Checks a BRR stream for problems, decoding it the way the S-DSP does. loopOffset is
+// the byte offset of the loop block, or -1 if it isn't known. When the stream loops
+// and the loop offset is known, the passes through the loop are checked too. Returns
+// ErrInvalidBrr if the data isn't whole blocks.
+func ValidateBrr(brrData []byte, loopOffset int) ([]Diagnostic, error) {
+	if len(brrData) == 0 || len(brrData)%9 != 0 {
+		return nil, fmt.Errorf("%w: length %d is not a multiple of 9", ErrInvalidBrr,
+			len(brrData))
+	}
+
+	diagnostics := []Diagnostic{}
+	add := func(severity Severity, kind DiagnosticKind, block int, sample int,
+		message string) {
+
+		offset := -1
+		if block >= 0 {
+			offset = block * 9
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: severity,
+			Kind:     kind,
+			Block:    block,
+			Offset:   offset,
+			Sample:   sample,
+			Message:  message,
+		})
+	}
+
+	blocks := len(brrData) / 9
+	for b := 0; b < blocks; b++ {
+		header := brrData[b*9]
+		last := b == blocks-1
+		if header&1 != 0 && !last {
+			add(SeverityWarning, KindStrayEnd, b, -1, "END flag before the last block")
+		}
+		if header&2 != 0 && !last {
+			add(SeverityWarning, KindStrayLoop, b, -1, "LOOP flag on a block that doesn't end")
+		}
+		if brange := int(header >> 4); brange > kMaxValidRange {
+			add(SeverityWarning, KindInvalidRange, b, -1, fmt.Sprintf("range %d", brange))
+		}
+	}
+
+	if brrData[0]&0x0C != 0 {
+		add(SeverityWarning, KindFirstFilter, 0, -1,
+			fmt.Sprintf("filter %d on the first block", brrData[0]>>2&3))
+	}
+
+	end := brrData[len(brrData)-9]
+	if end&1 == 0 {
+		add(SeverityError, KindMissingEnd, blocks-1, -1, "the last block has no END flag")
+	}
+
+	if end&3 == 1 && loopOffset >= 0 {
+		add(SeverityWarning, KindIgnoredLoop, blocks-1, -1,
+			fmt.Sprintf("loop offset %d is ignored, the last block has no LOOP flag",
+				loopOffset))
+	}
+
+	looped := end&3 == 3 && loopOffset >= 0
+	if looped && (loopOffset%9 != 0 || loopOffset >= len(brrData)) {
+		add(SeverityError, KindInvalidLoop, -1, -1,
+			fmt.Sprintf("loop offset %d is not the start of a block", loopOffset))
+		looped = false
+	}
+
+	diagnostics = append(diagnostics, checkDecoding(brrData, 0, brrHistory{}, false)...)
+
+	if looped {
+		// Only report problems that the first pass doesn't already have.
+		type location struct {
+			kind   DiagnosticKind
+			block  int
+			sample int
+		}
+		seen := map[location]bool{}
+		for _, d := range diagnostics {
+			seen[location{d.Kind, d.Block, d.Sample}] = true
+		}
+
+		_, reentry := loopHistories(brrData, loopOffset)
+		for _, h := range reentry {
+			for _, d := range checkDecoding(brrData, loopOffset, h, true) {
+				key := location{d.Kind, d.Block, d.Sample}
+				if !seen[key] {
+					seen[key] = true
+					diagnostics = append(diagnostics, d)
+				}
+			}
+		}
+	}
+
+	return diagnostics, nil
+}
+
+// Decodes the BRR data from the given offset and history, and reports samples that wrap
+// around or can overflow the gaussian interpolation.
+func checkDecoding(brrData []byte, offset int, h brrHistory, looped bool) []Diagnostic {
+	diagnostics := []Diagnostic{}
+	add := func(kind DiagnosticKind, index int, message string) {
+		block := offset/9 + index/16
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityWarning,
+			Kind:     kind,
+			Block:    block,
+			Offset:   block * 9,
+			Sample:   index % 16,
+			Looped:   looped,
+			Message:  message,
+		})
+	}
+
+	// The history is included so that the gaussian check covers the first samples.
+	window := []int16{int16(h.prev2), int16(h.prev1)}
+	index := 0
+	for b := offset; b < len(brrData); b += 9 {
+		header := brrData[b]
+		filter := int(header>>2) & 3
+
+		var samples [16]int
+		var overflow uint16
+		samples, h.prev1, h.prev2, overflow = decodeBlockHw(brrData[b:b+9], h.prev1, h.prev2)
+
+		for i, s := range samples {
+			if overflow&(1<<i) != 0 {
+				add(KindWraparound, index,
+					fmt.Sprintf("filter %d accumulation wraps to %d", filter, s<<1))
+			}
+
+			window = append(window, int16(s))
+			if testOverflow(window[len(window)-3:]) != 0 {
+				add(KindGaussianOverflow, index, "samples near full scale can overflow the "+
+					"gaussian interpolation")
+			}
+			index++
+		}
+
+		if header&1 != 0 {
+			break
+		}
+	}
+
+	return diagnostics
+}
@@ -0,0 +1,114 @@
+// snesbrr
+// Copyright 2025 Mukunda Johnson (mukunda.com)
+// Licensed under MIT
+
+package brr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Returns the kinds of the diagnostics.
+func diagnosticKinds(diagnostics []Diagnostic) []DiagnosticKind {
+	kinds := []DiagnosticKind{}
+	for _, d := range diagnostics {
+		kinds = append(kinds, d.Kind)
+	}
+	return kinds
+}
+
+// Returns the first diagnostic of the given kind, or false if there isn't one.
+func findDiagnostic(diagnostics []Diagnostic, kind DiagnosticKind) (Diagnostic, bool) {
+	for _, d := range diagnostics {
+		if d.Kind == kind {
+			return d, true
+		}
+	}
+	return Diagnostic{}, false
+}
+
+func TestValidateBrr(t *testing.T) {
+	codec := NewCodec()
+	codec.PcmData = createPeriodicPcm16(1600, 100)
+	codec.SetLoop(800)
+	codec.Encode()
+
+	diagnostics, err := ValidateBrr(codec.BrrData, codec.LoopOffset)
+	assert.NoError(t, err)
+	assert.Empty(t, diagnostics)
+
+	brrData := []byte{
+		// Filter 1 on the first block, and a stray LOOP flag.
+		0xC6, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// A flat level at negative full scale.
+		0xC0, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88,
+		// Filter 3 goes past full scale and wraps around.
+		0xCC, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88,
+		// Range 13 without an END flag.
+		0xD0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	diagnostics, err = ValidateBrr(brrData, -1)
+	assert.NoError(t, err)
+	kinds := diagnosticKinds(diagnostics)
+	assert.Subset(t, kinds, []DiagnosticKind{KindStrayLoop, KindInvalidRange,
+		KindFirstFilter, KindMissingEnd, KindWraparound, KindGaussianOverflow})
+	assert.NotContains(t, kinds, KindStrayEnd)
+
+	wraparound, ok := findDiagnostic(diagnostics, KindWraparound)
+	assert.True(t, ok)
+	assert.Equal(t, 2, wraparound.Block)
+	assert.Equal(t, 18, wraparound.Offset)
+	assert.Equal(t, SeverityWarning, wraparound.Severity)
+
+	missingEnd, ok := findDiagnostic(diagnostics, KindMissingEnd)
+	assert.True(t, ok)
+	assert.Equal(t, Diagnostic{Severity: SeverityError, Kind: KindMissingEnd, Block: 3,
+		Offset: 27, Sample: -1, Message: "the last block has no END flag"}, missingEnd)
+
+	// An END flag in the middle, and a bad loop offset.
+	brrData[0] = 0xC1
+	brrData[27] = 0xD3
+	diagnostics, _ = ValidateBrr(brrData, 10)
+	kinds = diagnosticKinds(diagnostics)
+	assert.Contains(t, kinds, KindStrayEnd)
+	assert.Contains(t, kinds, KindInvalidLoop)
+	assert.NotContains(t, kinds, KindWraparound)
+
+	// A loop offset when the last block only has the END flag.
+	brrData[27] = 0xD1
+	diagnostics, _ = ValidateBrr(brrData, 9)
+	ignored, ok := findDiagnostic(diagnostics, KindIgnoredLoop)
+	assert.True(t, ok)
+	assert.Equal(t, SeverityWarning, ignored.Severity)
+	assert.Equal(t, 3, ignored.Block)
+	assert.NotContains(t, diagnosticKinds(diagnostics), KindInvalidLoop)
+
+	diagnostics, _ = ValidateBrr(brrData, -1)
+	assert.NotContains(t, diagnosticKinds(diagnostics), KindIgnoredLoop)
+
+	_, err = ValidateBrr(brrData[:10], -1)
+	assert.ErrorIs(t, err, ErrInvalidBrr)
+}
+
+func TestValidateBrrLoopPasses(t *testing.T) {
+	// The loop block uses filter 1, which only wraps around when it's re-entered with
+	// the high level left by the end of the sample.
+	brrData := []byte{
+		0xC0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xC4, 0x40, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0xC3, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77, 0x77,
+	}
+
+	diagnostics, err := ValidateBrr(brrData, 9)
+	assert.NoError(t, err)
+	looped := 0
+	for _, d := range diagnostics {
+		if d.Looped {
+			looped++
+			assert.Equal(t, KindWraparound, d.Kind)
+		}
+	}
+	assert.NotZero(t, looped)
+}
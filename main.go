@@ -14,11 +14,20 @@ Basic usage:
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 
 	"go.mukunda.com/snesbrr/v2/brr"
 )
@@ -39,6 +48,17 @@ Command Line Options
    Decoding mode. The input (raw BRR file) will be decoded
    and saved to the output file in WAV format.
 
+--jobs N
+   Batch mode: when the input is a directory or a quoted
+   glob pattern such as "sounds/*.wav", every matching file
+   is processed, N files at a time (default: the number of
+   CPUs). Directories are searched recursively for .wav
+   files when encoding, or .brr files when decoding. The
+   output argument is an output directory, which mirrors
+   the directory structure of the input. Without it, each
+   output is written next to its input. A summary of each
+   file is printed, and failures don't stop the batch.
+
 -l START, --loop START
    Specify the starting sample index of the loop with the
    decimal value START. If the loop start or loop length is
@@ -46,6 +66,149 @@ Command Line Options
    be unrolled to align, increasing the output size. Looping
    is disabled by default.
 
+   If START is "auto", the input is searched for loop
+   points that match the end of the sample, preferring
+   points that don't need to be unrolled. The best
+   candidates are printed and the first one is used.
+
+--loop-align unroll|resample
+   Sets how a loop that doesn't fit BRR blocks (16
+   samples) is aligned. "unroll" (the default) repeats the
+   loop until it fits, which keeps the pitch exact but can
+   make the output up to 16 times larger. "resample"
+   stretches the loop to fit and moves the loop start up
+   to 15 samples later, repeating the skipped samples at
+   the end of the loop. The start of the sample isn't
+   changed. The pitch change of the loop is printed, in
+   cents, with a warning when it's over 10 cents.
+
+--loop-crossfade N
+   Crossfade the last N samples of the loop into the N
+   samples leading up to the loop start before encoding,
+   so that the loop seam is smooth. N is in input samples.
+
+--crossfade-curve linear|equal-power
+   Sets the curve used by --loop-crossfade. Uses "linear"
+   by default. "equal-power" keeps the level steadier when
+   the two sides of the seam are different.
+
+--mix average|left|right|mid|side|W1,W2,...
+   Sets how multichannel WAV input is mixed down to one
+   channel when encoding. "average" (the default) mixes all
+   channels equally, "mid" and "side" are (L+R)/2 and
+   (L-R)/2, and a comma separated list gives the weight of
+   each channel. A warning is printed if the channels
+   appear to cancel each other out.
+
+--channel N
+   Encode only channel N of a multichannel WAV file, where
+   0 is the left channel.
+
+--rate HZ
+   Resample the input to HZ before encoding. The loop start
+   is given in input samples and is converted along with
+   it. By default, the input is encoded at its own rate.
+
+--max-bytes N
+   Encode at the highest sample rate that fits the BRR
+   output in N bytes. The rate starts at --rate, or the
+   input rate, and goes down to --min-rate. For a looped
+   sample, the loop alignment that allows the highest rate
+   is used. A sample without a loop is cut short if it
+   doesn't fit at --min-rate. The chosen rate and the
+   resulting quality are printed.
+
+--min-rate HZ
+   Sets the lowest sample rate that --max-bytes will use.
+   Default is 8000.
+
+--resampler sinc|linear
+   Sets the resampling method used by --rate. Uses "sinc"
+   (windowed-sinc) by default.
+
+--resample-quality N
+   Sets the number of sinc zero crossings (1-64) used by
+   the sinc resampler. Higher is slower but cleaner.
+   Default is 16.
+
+--format auto|raw|amk|ca65|wla|asar|c|go
+   Sets the BRR file format. "raw" is plain BRR data. "amk"
+   has a 2-byte loop offset header, as used by AddMusicK.
+   "auto" (the default) writes raw files and detects the
+   loop header when reading files with a length that is 2
+   more than a multiple of 9.
+
+   "ca65", "wla", "asar", "c" and "go" write the BRR data as
+   source text to include in a ca65, WLA-DX, asar, C or Go
+   project. The data is labeled, with constants for the
+   length and the loop offset (0 without a loop), such as
+   piano, piano_LENGTH and piano_LOOP for ca65. The default
+   output file extension matches the format. These formats
+   can only be written.
+
+--label NAME
+   Sets the label for source formats. The default is the
+   output file name without its extension. In batch mode,
+   each file is labeled with its name, and --label can't
+   be used.
+
+--package NAME
+   Sets the package of "go" source output. The default is
+   brrdata. The Go identifiers are exported, such as
+   Piano, PianoLength and PianoLoop.
+
+--stats
+   Print statistics about the encoding error, such as the
+   signal-to-noise ratio and the filters and ranges used.
+
+--play-seconds SECONDS
+   When decoding, play the sample for SECONDS, following
+   the loop if the last block has the LOOP flag, so that
+   clicks at the loop point can be heard. The output is
+   padded with silence if the sample ends first.
+
+--loop-count N
+   When decoding, jump back to the loop point N times. With
+   --play-seconds, playback stops at whichever comes first.
+
+--loop-offset BYTES
+   Sets the byte offset of the loop block for decoding
+   with --play-seconds and --loop-count. Raw BRR files
+   don't store it, so the start of the sample is used by
+   default, unless the file has a loop header.
+
+--dsp
+   When decoding, play the BRR data through an emulated
+   S-DSP voice, so the output sounds like the SNES: the
+   pitch and volume are applied along with the gaussian
+   filter, and the output is always 32000 Hz. Works with
+   either codec.
+
+--pitch N
+   Sets the voice pitch register (0x0001-0x3FFF) for
+   --dsp, in decimal or in hexadecimal with a "0x" prefix.
+   0x1000 (the default) plays the sample at 32000 Hz.
+
+--volume N
+   Sets the voice volume register (-128 to 127) for --dsp.
+   Default is 127.
+
+--adsr A,D,SL,SR
+   Use an ADSR envelope for --dsp, with attack rate A
+   (0-15), decay rate D (0-7), sustain level SL (0-7) and
+   sustain rate SR (0-31). Implies --dsp.
+
+--gain N
+   Use a GAIN envelope for --dsp with the register value N
+   (0-255), in decimal or in hexadecimal with a "0x"
+   prefix. The default is 0x7F, a fixed full volume.
+   Implies --dsp.
+
+--key-off SECONDS
+   Key off the voice after SECONDS, so the release of the
+   envelope is heard. By default, the note is held. Implies
+   --dsp.
+
 --codec noc|dmv
    Sets the codec implementation to "dmv" or "noc". Uses
 	"noc" by default, which is a newer implementation. "dmv"
@@ -67,13 +230,187 @@ gauss = 1 | 0 (default: 0)
    For the dmv codec only, setting it to "1" will apply a
    gaussian filter, simulating how the SNES sounds.
 
+lookahead = 0-3 (default: 0)
+   For the noc codec only. When choosing the filter and
+   range of each block, also search this many following
+   blocks for the lowest combined error. Filters carry
+   over from one block to the next, so this can reduce
+   noise in filtered material. The best 4 encodings of
+   each block are followed, so each level makes encoding
+   about 4x slower.
+
+search = round | beam | exhaustive (default: round)
+   For the noc codec only. Sets how the 4-bit samples of
+   each block are chosen. "round" rounds each sample to
+   the nearest value. "beam" and "exhaustive" search for
+   a combination of samples with lower error for the
+   whole block, since each sample feeds the filter of the
+   next. Only the 4 filters and ranges with the lowest
+   rounded error are searched. "beam" tries the rounded
+   value and its neighbors for each sample and keeps the
+   best 16 paths. "exhaustive" tries every value for each
+   sample, but it also only keeps the best 256 paths, so
+   despite its name it isn't guaranteed to find the best
+   block either. It's the slowest. With --stats, the
+   error saved by the search is printed.
+
+loopfit = 1 | 0 (default: 0)
+   For the noc codec only. Normally, filters aren't used
+   on the loop block, since the filter history when the
+   loop repeats comes from the end of the sample. Setting
+   this to "1" allows filters on the loop block when they
+   work for every pass through the loop, which can reduce
+   noise in looped samples. The whole loop is decoded from
+   the history of each pass, and if anything would wrap
+   around or the passes don't settle, filter 0 is used
+   after all. With --stats, any remaining difference
+   between passes is printed. The dmv codec always uses
+   filter 0 on the loop block.
+
+hw = 1 | 0 (default: 0)
+   For the noc codec only. Setting this to "1" decodes the
+   way the S-DSP does: the filter accumulation saturates to
+   16 bits and then wraps around to 15 bits, and ranges
+   13-15 decode to -2048 or 0. By default, the noc decoder
+   only clamps the output, which matches the hardware for
+   everything the encoders produce but not for all data.
+
+gaussguard = 1 | 0 (default: 1)
+   For the noc codec only. The S-DSP's gaussian
+   interpolation overflows and inverts the sign at a few
+   interpolation positions when three samples in a row
+   are too close to full scale, which makes a pop. By
+   default, the encoder keeps the decoded samples clear of
+   that, rounding them toward zero where needed. Setting
+   this to "0" turns the guard off, which slightly lowers
+   the error of very loud samples. The runs across the
+   loop seam are kept clear too: filter 0 can't decode
+   that close to full scale, and it's used on the loop
+   block unless loopfit finds a filter that's clear for
+   every pass through the loop.
+
 pitch = 0x0001-0x3FFF (default: 0x1000)
    For the dmv codec only. This sets a pitch rate for the
    output to the hexadecimal number. This interacts with the
-	gaussian filtering.`
+	gaussian filtering.
+
+Build Command
+-------------
+snesbrr build MANIFEST
+   Encodes every sample listed in a JSON manifest with its
+   own options, and writes the BRR files and a combined
+   bank. Paths are relative to the manifest. The build
+   fails if any sample fails or is larger than its
+   max_bytes. Example:
+
+   {
+     "output_dir": "build",
+     "bank": "samples.bin",
+     "samples": [
+       {
+         "name": "piano",
+         "input": "piano.wav",
+         "loop": 1234,
+         "rate": 16000,
+         "codec": "noc",
+         "options": { "lookahead": "2" },
+         "max_bytes": 8000
+       },
+       { "input": "drum.wav", "loop": "auto" }
+     ]
+   }
+
+   Each sample is written to output_dir as NAME.brr,
+   unless "output" is given. NAME defaults to the input
+   file name. Names and outputs must be unique, and a
+   sample over its max_bytes isn't written. A numeric
+   "loop" must be a whole number. Other sample settings
+   are "resampler", "loop_align", "loop_crossfade",
+   "crossfade_curve", "mix", and "channel", which work
+   like the options above.
+
+   The bank is all of the BRR data, one sample after the
+   other. If "base" is given, such as "0x0400", the bank is
+   laid out to be loaded at that ARAM address, which must
+   be a multiple of 0x100. It starts with a sample
+   directory for the DSP's DIR register: a 4-byte entry for
+   each sample with its start address and loop address.
+   The BRR data follows. The directory holds up to 256
+   samples. "symbols" names a file to write a listing of
+   the directory address and each sample's index,
+   addresses and size to, as assembler equates. Sample
+   names must still be unique after characters that can't
+   be used in a symbol are replaced with underscores.
+
+Extract SPC Command
+-------------------
+snesbrr extract-spc SPCFILE [OUTPUT-DIR]
+   Extracts the samples from an SPC file. The sample
+   directory is found with the DSP's DIR register, and its
+   entries are read in order, skipping ones that don't
+   point to valid BRR data, until 16 in a row are invalid.
+   The samples that the voices are set to are also
+   included. Entries with the same start and loop address
+   are only extracted once. Each sample is written to the
+   output directory as NN.brr, with the directory index in
+   hex, in the "amk" format so that the loop offset is
+   kept, and decoded to NN.wav. The output directory
+   defaults to the SPC file name without its extension.
+
+Scan Command
+------------
+snesbrr scan [--min-blocks N] [--min-score S] [--dump DIR] FILE
+   Scans any binary file, such as a ROM image, for data
+   that looks like BRR samples, and lists the offset, size
+   and score of each. A sample must use valid ranges,
+   start with a filter 0 block, end with the end flag, and
+   decode to a reasonable level. The score (0-1) rates how
+   much the decoded data looks like audio rather than
+   noise. Leading blocks that are much noisier than the
+   rest are left out of the sample.
+
+   --min-blocks sets the shortest sample to report, in
+   blocks. Default is 16. --min-score sets the lowest score
+   to report. Default is 0.5. --dump writes each sample to
+   the directory as OFFSET.brr, with the offset in hex,
+   and decoded to OFFSET.wav. Loop offsets aren't stored
+   in the BRR data, so the dumped files don't loop.
+
+Inspect Command
+---------------
+snesbrr inspect FILE
+   Lists every block of a BRR file: its offset, range,
+   filter, END (E) and LOOP (L) flags, peak level and the
+   16 decoded samples. The loop block is marked when the
+   file has a loop header. Warnings are printed for ranges
+   13-15, filter accumulation that overflows, and END
+   flags before the last block. --format works as it does
+   for decoding.
+
+Validate Command
+----------------
+snesbrr validate [--loop-offset N] FILE
+   Checks a BRR file for problems, decoding it the way the
+   S-DSP does, and lists each with its block and sample.
+   Errors are a missing END flag on the last block and a
+   loop offset that isn't the start of a block. Warnings
+   are a loop offset when the last block has no LOOP flag,
+   END or LOOP flags in the middle of the data, ranges
+   13-15, a filter on the first block, filter accumulation
+   that wraps around to the opposite sign, and samples so
+   close to full scale that the gaussian interpolation can
+   overflow. When the loop offset is known, from the file's
+   loop header or --loop-offset, later passes through the
+   loop are checked too. Exits with 1 if there are errors.
+   --format works as it does for decoding.`
 
 func printUsage(short bool) {
 	fmt.Println("Usage: [options] input-file output-file")
+	fmt.Println("       build manifest-file")
+	fmt.Println("       extract-spc spc-file [output-dir]")
+	fmt.Println("       scan [scan-options] file")
+	fmt.Println("       inspect brr-file")
+	fmt.Println("       validate [--loop-offset N] brr-file")
 	if short {
 		fmt.Println("Use --help for options help.")
 		return
@@ -99,14 +436,42 @@ type programArgs struct {
 	Help       bool
 	Encode     bool
 	Decode     bool
-	Loop       int
+	Loop       string
 	Opts       codecOptions
 	Codec      string
+	LoopAlign  string
+	MaxBytes   int
+	MinRate    int
+	Crossfade  int
+	Curve      string
+	Mix        string
+	Channel    int
+	Rate       int
+	Resampler  string
+	Quality    int
+	Format     string
+	Label      string
+	Package    string
+	Stats      bool
+	Jobs       int
+	Dsp        bool
+	Pitch      string
+	Volume     int
+	Adsr       string
+	Gain       string
+	KeyOff     float64
+	PlayTime   float64
+	LoopCount  int
+	LoopOffset int
 }
 
 var ErrShowHelp = errors.New("show help")
 var ErrInvalidArgs = errors.New("invalid arguments")
 
+// Largest pitch change, in cents, from aligning a loop by resampling that isn't warned
+// about.
+const kMaxLoopPitchCents = 10
+
 func parseArgs(argSet []string) (programArgs, error) {
 	args := programArgs{}
 
@@ -121,11 +486,42 @@ func parseArgs(argSet []string) (programArgs, error) {
 	flagSet.BoolVar(&args.Decode, "decode", false, "Decode BRR -> WAV")
 	flagSet.BoolVar(&args.Decode, "d", false, "Decode BRR -> WAV")
 
-	flagSet.IntVar(&args.Loop, "loop", -1, "Set the loop start sample")
-	flagSet.IntVar(&args.Loop, "l", -1, "Set the loop start sample")
+	flagSet.StringVar(&args.Loop, "loop", "", "Set the loop start sample")
+	flagSet.StringVar(&args.Loop, "l", "", "Set the loop start sample")
+
+	flagSet.StringVar(&args.LoopAlign, "loop-align", "unroll", "Set the loop alignment method")
+	flagSet.IntVar(&args.Crossfade, "loop-crossfade", 0, "Crossfade the loop seam")
+	flagSet.StringVar(&args.Curve, "crossfade-curve", "linear", "Set the crossfade curve")
 
 	flagSet.StringVar(&args.Codec, "codec", "", "Set the codec implementation")
 
+	flagSet.StringVar(&args.Mix, "mix", "", "Set the multichannel downmix mode")
+	flagSet.IntVar(&args.Channel, "channel", -1, "Select a single input channel")
+
+	flagSet.IntVar(&args.Rate, "rate", 0, "Resample the input before encoding")
+	flagSet.IntVar(&args.MaxBytes, "max-bytes", 0, "Fit the BRR output in a byte budget")
+	flagSet.IntVar(&args.MinRate, "min-rate", 0, "Set the lowest rate for --max-bytes")
+	flagSet.StringVar(&args.Resampler, "resampler", "sinc", "Set the resampling method")
+	flagSet.IntVar(&args.Quality, "resample-quality", 0, "Set the resampling quality")
+
+	flagSet.StringVar(&args.Format, "format", "auto", "Set the BRR file format")
+	flagSet.StringVar(&args.Label, "label", "", "Set the label for source formats")
+	flagSet.StringVar(&args.Package, "package", "", "Set the package for Go source")
+
+	flagSet.BoolVar(&args.Stats, "stats", false, "Print encoding statistics")
+	flagSet.IntVar(&args.Jobs, "jobs", 0, "Set the number of files processed at once")
+
+	flagSet.Float64Var(&args.PlayTime, "play-seconds", 0, "Set the decoding length")
+	flagSet.IntVar(&args.LoopCount, "loop-count", -1, "Set the number of loops to decode")
+	flagSet.IntVar(&args.LoopOffset, "loop-offset", -1, "Set the loop offset for decoding")
+
+	flagSet.BoolVar(&args.Dsp, "dsp", false, "Decode through an emulated S-DSP voice")
+	flagSet.StringVar(&args.Pitch, "pitch", "0x1000", "Set the S-DSP voice pitch")
+	flagSet.IntVar(&args.Volume, "volume", 127, "Set the S-DSP voice volume")
+	flagSet.StringVar(&args.Adsr, "adsr", "", "Set an ADSR envelope for the S-DSP voice")
+	flagSet.StringVar(&args.Gain, "gain", "", "Set a GAIN envelope for the S-DSP voice")
+	flagSet.Float64Var(&args.KeyOff, "key-off", 0, "Key off the S-DSP voice after a time")
+
 	flagSet.Var(&args.Opts, "opt", "Set codec options in the form OPT=VALUE")
 
 	err := flagSet.Parse(argSet)
@@ -133,6 +529,13 @@ func parseArgs(argSet []string) (programArgs, error) {
 	if err == nil {
 		args.InputFile = flagSet.Arg(0)
 		args.OutputFile = flagSet.Arg(1)
+
+		// -1 means no channel is selected, so only a given value is checked.
+		flagSet.Visit(func(f *flag.Flag) {
+			if f.Name == "channel" && args.Channel < 0 {
+				err = fmt.Errorf("%w: --channel must be 0 or more", ErrInvalidArgs)
+			}
+		})
 	}
 
 	return args, err
@@ -152,7 +555,110 @@ func parseCodecOpt(opt string) (string, string) {
 	return key, value
 }
 
+// Parses "A,D,SL,SR" into an ADSR envelope.
+func parseAdsr(adsr string) (brr.Envelope, error) {
+	parts := strings.Split(adsr, ",")
+	if len(parts) != 4 {
+		return brr.Envelope{}, fmt.Errorf("%w: --adsr must be A,D,SL,SR", ErrInvalidArgs)
+	}
+
+	values := [4]int{}
+	for i, part := range parts {
+		value, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return brr.Envelope{}, fmt.Errorf("%w: --adsr %s", ErrInvalidArgs, adsr)
+		}
+		values[i] = value
+	}
+
+	return brr.Envelope{
+		ADSR:         true,
+		Attack:       values[0],
+		Decay:        values[1],
+		SustainLevel: values[2],
+		SustainRate:  values[3],
+	}, nil
+}
+
+// Searches for loop points, prints the best ones, and sets the loop to the first. Returns
+// false if no loop points were found.
+func findLoop(w io.Writer, codec *brr.BrrCodec) bool {
+	candidates := codec.FindLoopPoints(5)
+	if len(candidates) == 0 {
+		return false
+	}
+
+	fmt.Fprintln(w, "Loop candidates:")
+	for _, c := range candidates {
+		aligned := ""
+		if c.Aligned {
+			aligned = ", aligned"
+		}
+		fmt.Fprintf(w, "  %8d  seam error %.4f, correlation %.4f%s\n", c.Start, c.Score,
+			c.Correlation, aligned)
+	}
+
+	codec.SetLoop(candidates[0].Start)
+	fmt.Fprintf(w, "Loop start: %d\n", candidates[0].Start)
+	return true
+}
+
+func printBudget(w io.Writer, budget brr.BudgetResult) {
+	fmt.Fprintf(w, "Budget: %d bytes at %d Hz, SNR %.2f dB", budget.Bytes, budget.Rate,
+		budget.Stats.SNR)
+	if budget.LoopAlign != "" {
+		fmt.Fprintf(w, ", loop %s", budget.LoopAlign)
+	}
+	if budget.TruncatedSamples > 0 {
+		fmt.Fprintf(w, ", %d samples cut from the end", budget.TruncatedSamples)
+	}
+	fmt.Fprintln(w)
+}
+
+func printStats(w io.Writer, stats brr.EncodingStats) {
+	fmt.Fprintf(w, "Blocks:            %d\n", stats.Blocks)
+	fmt.Fprintf(w, "SNR:               %.2f dB\n", stats.SNR)
+	fmt.Fprintf(w, "Total error:       %.0f\n", stats.TotalError)
+	fmt.Fprintf(w, "Block error:       avg %.1f, min %.0f, max %.0f\n",
+		stats.AvgError, stats.MinError, stats.MaxError)
+	fmt.Fprintf(w, "Peak sample error: %.0f\n", stats.PeakError)
+	fmt.Fprintf(w, "Clipped samples:   %d\n", stats.ClippedSamples)
+	if stats.SearchGain > 0 {
+		fmt.Fprintf(w, "Search gain:       %.0f\n", stats.SearchGain)
+	}
+
+	fmt.Fprint(w, "Filters:          ")
+	for filter, count := range stats.FilterHistogram {
+		fmt.Fprintf(w, " %d:%d", filter, count)
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprint(w, "Ranges:           ")
+	for brange, count := range stats.RangeHistogram {
+		if count > 0 {
+			fmt.Fprintf(w, " %d:%d", brange, count)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
 func run(cliArgs []string) returnCode {
+	if len(cliArgs) > 0 && cliArgs[0] == "build" {
+		return runBuild(cliArgs[1:])
+	}
+	if len(cliArgs) > 0 && cliArgs[0] == "extract-spc" {
+		return runExtractSpc(cliArgs[1:])
+	}
+	if len(cliArgs) > 0 && cliArgs[0] == "scan" {
+		return runScan(cliArgs[1:])
+	}
+	if len(cliArgs) > 0 && cliArgs[0] == "inspect" {
+		return runInspect(cliArgs[1:])
+	}
+	if len(cliArgs) > 0 && cliArgs[0] == "validate" {
+		return runValidate(cliArgs[1:])
+	}
+
 	args, argsErr := parseArgs(cliArgs)
 
 	if args.Help || errors.Is(argsErr, flag.ErrHelp) {
@@ -162,6 +668,7 @@ func run(cliArgs []string) returnCode {
 
 	if argsErr != nil {
 		fmt.Printf("Error: %v\n", argsErr)
+		return 1
 	}
 
 	if args.InputFile == "" {
@@ -180,9 +687,18 @@ func run(cliArgs []string) returnCode {
 		return 1
 	}
 
+	if isBatchInput(args.InputFile) {
+		if args.Label != "" {
+			fmt.Println("Error: --label can't be used in batch mode. Each file is labeled" +
+				" with its name.")
+			return 1
+		}
+		return runBatch(args)
+	}
+
 	if args.OutputFile == "" {
 		if args.Encode {
-			args.OutputFile = args.InputFile + ".brr"
+			args.OutputFile = args.InputFile + brrFileExt(args.Format)
 		} else {
 			args.OutputFile = args.InputFile + ".wav"
 		}
@@ -193,50 +709,796 @@ func run(cliArgs []string) returnCode {
 		}
 	}
 
+	codec, err := createCodec(args)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	if args.Encode {
+		err = encodeFile(args, codec, args.InputFile, args.OutputFile, os.Stdout)
+	} else {
+		err = decodeFile(args, codec, args.InputFile, args.OutputFile)
+	}
+
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// Creates a codec with the settings given on the command line.
+func createCodec(args programArgs) (*brr.BrrCodec, error) {
 	codec := brr.NewCodec()
 
 	if args.Codec != "" {
 		err := codec.SetCodecImplementation(args.Codec)
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return 1
+			return nil, err
 		}
 	}
 
-	if args.Loop >= 0 {
-		codec.SetLoop(args.Loop)
+	if args.Loop != "" && args.Loop != "auto" {
+		loop, err := strconv.Atoi(args.Loop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid loop start %s", args.Loop)
+		}
+		codec.SetLoop(loop)
+	}
+
+	if err := codec.SetLoopAlign(args.LoopAlign); err != nil {
+		return nil, err
+	}
+
+	if err := codec.SetLoopCrossfade(args.Crossfade, args.Curve); err != nil {
+		return nil, err
+	}
+
+	if err := codec.SetBrrFormat(args.Format); err != nil {
+		return nil, err
+	}
+	codec.SetSourceLabel(args.Label)
+	codec.SetSourcePackage(args.Package)
+
+	if args.Rate < 0 {
+		return nil, errors.New("--rate must be positive")
+	}
+	codec.SetTargetRate(brr.SampleRate(args.Rate))
+
+	if err := codec.SetResampler(args.Resampler, args.Quality); err != nil {
+		return nil, err
+	}
+
+	if args.Mix != "" && args.Channel >= 0 {
+		return nil, errors.New("--mix and --channel can't be used together")
+	}
+
+	if args.Mix != "" {
+		if err := codec.SetChannelMix(args.Mix); err != nil {
+			return nil, err
+		}
+	}
+
+	if args.Channel >= 0 {
+		if err := codec.SetChannel(args.Channel); err != nil {
+			return nil, err
+		}
+	}
+
+	if args.Adsr != "" && args.Gain != "" {
+		return nil, errors.New("--adsr and --gain can't be used together")
+	}
+
+	if args.Dsp || args.Adsr != "" || args.Gain != "" || args.KeyOff > 0 {
+		pitch, err := strconv.ParseInt(args.Pitch, 0, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pitch %s", args.Pitch)
+		}
+
+		settings := brr.DefaultVoiceSettings()
+		settings.Pitch = int(pitch)
+		settings.Volume = args.Volume
+		settings.KeyOff = int(args.KeyOff * float64(brr.DspRate))
+
+		if args.Adsr != "" {
+			if settings.Envelope, err = parseAdsr(args.Adsr); err != nil {
+				return nil, err
+			}
+		}
+
+		if args.Gain != "" {
+			gain, err := strconv.ParseInt(args.Gain, 0, 0)
+			if err != nil {
+				return nil, fmt.Errorf("invalid gain %s", args.Gain)
+			}
+			settings.Envelope = brr.Envelope{Gain: int(gain)}
+		}
+
+		if err := codec.SetVoice(&settings); err != nil {
+			return nil, err
+		}
 	}
 
 	for _, opt := range args.Opts {
 		key, value := parseCodecOpt(opt)
 		if err := codec.SetCodecOption(key, value); err != nil {
-			fmt.Printf("Error: %v\n", err)
-			return 1
+			return nil, err
+		}
+	}
+
+	return codec, nil
+}
+
+// Encodes a WAV file to a BRR file. Messages are written to w.
+func encodeFile(args programArgs, codec *brr.BrrCodec, input string, output string,
+	w io.Writer) error {
+	if err := encodeInput(args, codec, input, w); err != nil {
+		return err
+	}
+
+	if err := codec.WriteBrrFile(output); err != nil {
+		return fmt.Errorf("creating output file: %w", err)
+	}
+
+	return nil
+}
+
+// Reads and encodes the input file into the codec's BRR data, without writing it.
+func encodeInput(args programArgs, codec *brr.BrrCodec, input string, w io.Writer) error {
+	if err := codec.ReadWavFile(input); err != nil {
+		return fmt.Errorf("loading input: %w", err)
+	}
+
+	if codec.DownmixStats().PhaseCancellation {
+		fmt.Fprintln(w, "Warning: input channels appear to cancel each other out when mixed."+
+			" Consider --mix left, --mix right, or --channel.")
+	}
+
+	if args.Loop == "auto" {
+		if !findLoop(w, codec) {
+			return errors.New("no loop points found")
+		}
+	}
+
+	var result brr.EncodeResult
+	loopAlign := args.LoopAlign
+	if args.MaxBytes > 0 {
+		budget, err := codec.EncodeBudget(args.MaxBytes, brr.SampleRate(args.MinRate))
+		if err != nil {
+			return err
+		}
+		printBudget(w, budget)
+		result = budget.EncodeResult
+		if budget.LoopAlign != "" {
+			loopAlign = budget.LoopAlign
+		}
+	} else {
+		result = codec.Encode()
+	}
+
+	if loopAlign == "resample" && result.LoopBlock >= 0 {
+		fmt.Fprintf(w, "Loop resampled: %+.2f cents.\n", result.LoopPitchCents)
+		if math.Abs(result.LoopPitchCents) > kMaxLoopPitchCents {
+			fmt.Fprintln(w, "Warning: the loop is audibly out of tune. Consider"+
+				" --loop-align unroll.")
+		}
+	}
+
+	if args.Stats {
+		printStats(w, codec.EncodingStats())
+		if result.LoopBlock >= 0 {
+			fmt.Fprintf(w, "Loop mismatch:     %d\n", result.LoopMismatch)
+		}
+	}
+
+	return nil
+}
+
+// Decodes a BRR file to a WAV file.
+func decodeFile(args programArgs, codec *brr.BrrCodec, input string, output string) error {
+	if err := codec.ReadBrrFile(input); err != nil {
+		return fmt.Errorf("loading input: %w", err)
+	}
+
+	if args.LoopOffset >= 0 {
+		if args.LoopOffset%9 != 0 || args.LoopOffset >= len(codec.BrrData) {
+			return fmt.Errorf("loop offset %d is not the start of a block", args.LoopOffset)
+		}
+		codec.LoopOffset = args.LoopOffset
+	}
+
+	codec.SetPlayDuration(args.PlayTime)
+	codec.SetLoopCount(args.LoopCount)
+	codec.Decode()
+
+	if err := codec.WriteWavFile(output); err != nil {
+		return fmt.Errorf("writing output: %w", err)
+	}
+
+	return nil
+}
+
+// One file of a batch.
+type batchJob struct {
+	input  string
+	output string
+
+	// Messages printed while processing the file.
+	log bytes.Buffer
+	err error
+}
+
+// Returns true if the input is a directory or a glob pattern, to be processed in batch
+// mode. A file that exists is always taken literally, even if its name has wildcard
+// characters.
+func isBatchInput(input string) bool {
+	if info, err := os.Stat(input); err == nil {
+		return info.IsDir()
+	}
+	return strings.ContainsAny(input, "*?[")
+}
+
+// Returns the directory that the files of a glob pattern are relative to: the part of
+// the pattern before the first wildcard.
+func globBase(pattern string) string {
+	i := strings.IndexAny(pattern, "*?[")
+	if i < 0 {
+		return filepath.Dir(pattern)
+	}
+	return filepath.Dir(pattern[:i] + "x")
+}
+
+// Returns the input files of a batch, and the directory that they're relative to. A
+// directory is searched recursively for files with the input extension.
+func findBatchInputs(input string, ext string) ([]string, string, error) {
+	files := []string{}
+
+	if info, err := os.Stat(input); err == nil && info.IsDir() {
+		err := filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.EqualFold(filepath.Ext(path), ext) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		return files, input, err
+	}
+
+	matches, err := filepath.Glob(input)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			files = append(files, path)
+		}
+	}
+	return files, globBase(input), nil
+}
+
+// Returns the output file for an input file of a batch. With an output directory, the
+// directory structure of the input is mirrored into it, and the extension is replaced.
+// Otherwise, the output goes next to the input with the extension added, like for a
+// single file.
+func batchOutput(input string, base string, outputDir string, ext string) (string, error) {
+	if outputDir == "" {
+		return input + ext, nil
+	}
+
+	rel, err := filepath.Rel(base, input)
+	if err != nil {
+		return "", err
+	}
+	rel = strings.TrimSuffix(rel, filepath.Ext(rel)) + ext
+	return filepath.Join(outputDir, rel), nil
+}
+
+// Returns the extension for BRR output files in the given format.
+func brrFileExt(format string) string {
+	switch format {
+	case "ca65", "wla", "asar":
+		return ".asm"
+	case "c":
+		return ".h"
+	case "go":
+		return ".go"
+	}
+	return ".brr"
+}
+
+// Encodes or decodes every file of a directory or glob pattern with a pool of workers.
+// The output file argument is the output directory. Failures are collected and
+// reported at the end instead of stopping the batch.
+func runBatch(args programArgs) returnCode {
+	inputExt, outputExt := ".wav", brrFileExt(args.Format)
+	if args.Decode {
+		inputExt, outputExt = ".brr", ".wav"
+	}
+
+	// Check the settings once before starting.
+	if _, err := createCodec(args); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	inputs, base, err := findBatchInputs(args.InputFile, inputExt)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if len(inputs) == 0 {
+		fmt.Println("Error: no input files found.")
+		return 1
+	}
+
+	jobs := make([]*batchJob, len(inputs))
+	for i, input := range inputs {
+		jobs[i] = &batchJob{input: input}
+		jobs[i].output, jobs[i].err = batchOutput(input, base, args.OutputFile, outputExt)
+	}
+
+	workers := args.Jobs
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	queue := make(chan *batchJob)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				job.err = processBatchJob(args, job)
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		if job.err == nil {
+			queue <- job
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	failures := []*batchJob{}
+	for _, job := range jobs {
+		if job.err != nil {
+			failures = append(failures, job)
+			fmt.Printf("FAIL %s: %v\n", job.input, job.err)
+		} else {
+			fmt.Printf("OK   %s -> %s\n", job.input, job.output)
+		}
+		for _, line := range strings.Split(strings.TrimRight(job.log.String(), "\n"), "\n") {
+			if line != "" {
+				fmt.Printf("     %s\n", line)
+			}
+		}
+	}
+
+	fmt.Printf("%d files, %d succeeded, %d failed.\n", len(jobs), len(jobs)-len(failures),
+		len(failures))
+	if len(failures) > 0 {
+		fmt.Println("Failures:")
+		for _, job := range failures {
+			fmt.Printf("  %s: %v\n", job.input, job.err)
+		}
+		return 1
+	}
+
+	return 0
+}
+
+// Encodes or decodes one file of a batch with its own codec.
+func processBatchJob(args programArgs, job *batchJob) error {
+	if args.OutputFile == "" {
+		if _, err := os.Stat(job.output); err == nil {
+			return errors.New("output file already exists")
 		}
 	}
 
+	if err := os.MkdirAll(filepath.Dir(job.output), 0755); err != nil {
+		return err
+	}
+
+	codec, err := createCodec(args)
+	if err != nil {
+		return err
+	}
+
 	if args.Encode {
-		if err := codec.ReadWavFile(args.InputFile); err != nil {
-			fmt.Printf("Error loading input. %v\n", err)
+		return encodeFile(args, codec, job.input, job.output, &job.log)
+	}
+	return decodeFile(args, codec, job.input, job.output)
+}
+
+// A list of samples to encode with the build command.
+type manifest struct {
+	OutputDir string           `json:"output_dir"`
+	Bank      string           `json:"bank"`
+	Base      any              `json:"base"`
+	Symbols   string           `json:"symbols"`
+	Samples   []manifestSample `json:"samples"`
+}
+
+// A sample of a manifest. The fields work like the command line options.
+type manifestSample struct {
+	Name           string            `json:"name"`
+	Input          string            `json:"input"`
+	Output         string            `json:"output"`
+	Loop           any               `json:"loop"`
+	Rate           int               `json:"rate"`
+	Resampler      string            `json:"resampler"`
+	Codec          string            `json:"codec"`
+	Options        map[string]string `json:"options"`
+	LoopAlign      string            `json:"loop_align"`
+	LoopCrossfade  int               `json:"loop_crossfade"`
+	CrossfadeCurve string            `json:"crossfade_curve"`
+	Mix            string            `json:"mix"`
+	Channel        *int              `json:"channel"`
+	MaxBytes       int               `json:"max_bytes"`
+}
+
+func readManifest(filename string) (manifest, error) {
+	m := manifest{}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return m, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&m); err != nil {
+		return m, fmt.Errorf("%w: %s: %v", ErrInvalidArgs, filename, err)
+	}
+
+	return m, nil
+}
+
+// Returns the command line arguments equivalent to the sample's settings. Paths are
+// relative to dir.
+func (ms manifestSample) programArgs(dir string, outputDir string) (programArgs, error) {
+	args, _ := parseArgs([]string{})
+	args.Encode = true
+
+	if ms.Input == "" {
+		return args, fmt.Errorf("%w: sample %q has no input", ErrInvalidArgs, ms.Name)
+	}
+	args.InputFile = filepath.Join(dir, ms.Input)
+	if ms.Output != "" {
+		args.OutputFile = filepath.Join(dir, ms.Output)
+	} else {
+		args.OutputFile = filepath.Join(outputDir, ms.Name+".brr")
+	}
+
+	switch loop := ms.Loop.(type) {
+	case nil:
+	case float64:
+		if loop != math.Trunc(loop) {
+			return args, fmt.Errorf("%w: sample %q has a loop that isn't a whole number",
+				ErrInvalidArgs, ms.Name)
+		}
+		args.Loop = strconv.Itoa(int(loop))
+	case string:
+		args.Loop = loop
+	default:
+		return args, fmt.Errorf("%w: sample %q has an invalid loop", ErrInvalidArgs, ms.Name)
+	}
+
+	args.Rate = ms.Rate
+	args.Codec = ms.Codec
+	args.Crossfade = ms.LoopCrossfade
+	args.Mix = ms.Mix
+	if ms.Resampler != "" {
+		args.Resampler = ms.Resampler
+	}
+	if ms.LoopAlign != "" {
+		args.LoopAlign = ms.LoopAlign
+	}
+	if ms.CrossfadeCurve != "" {
+		args.Curve = ms.CrossfadeCurve
+	}
+	if ms.Channel != nil {
+		if *ms.Channel < 0 {
+			return args, fmt.Errorf("%w: sample %q has a negative channel", ErrInvalidArgs,
+				ms.Name)
+		}
+		args.Channel = *ms.Channel
+	}
+	for key, value := range ms.Options {
+		args.Opts = append(args.Opts, key+"="+value)
+	}
+
+	return args, nil
+}
+
+// Encodes every sample of a manifest, and writes the combined bank. Every sample is
+// attempted, and the failures are listed at the end.
+func runBuild(cliArgs []string) returnCode {
+	if len(cliArgs) != 1 {
+		fmt.Println("Error: build needs a manifest file.")
+		printUsage(true)
+		return 1
+	}
+
+	m, err := readManifest(cliArgs[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	dir := filepath.Dir(cliArgs[0])
+	outputDir := filepath.Join(dir, m.OutputDir)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	base := -1
+	switch value := m.Base.(type) {
+	case nil:
+	case float64:
+		base = int(value)
+	case string:
+		parsed, err := strconv.ParseInt(value, 0, 0)
+		if err != nil {
+			fmt.Printf("Error: invalid base %s.\n", value)
 			return 1
 		}
+		base = int(parsed)
+	default:
+		fmt.Println("Error: invalid base.")
+		return 1
+	}
+
+	if m.Symbols != "" && base < 0 {
+		fmt.Println("Error: symbols need a base address.")
+		return 1
+	}
+
+	// Samples can't share a name or an output file, or one would overwrite the other.
+	names := map[string]bool{}
+	outputs := map[string]bool{}
+	for i := range m.Samples {
+		sample := &m.Samples[i]
+		if sample.Name == "" {
+			sample.Name = strings.TrimSuffix(filepath.Base(sample.Input),
+				filepath.Ext(sample.Input))
+		}
+		if names[sample.Name] {
+			fmt.Printf("Error: more than one sample is named %q.\n", sample.Name)
+			return 1
+		}
+		names[sample.Name] = true
+
+		// Samples with invalid settings fail when they're built.
+		if args, err := sample.programArgs(dir, outputDir); err == nil {
+			output := filepath.Clean(args.OutputFile)
+			if outputs[output] {
+				fmt.Printf("Error: more than one sample is written to %s.\n", output)
+				return 1
+			}
+			outputs[output] = true
+		}
+	}
+
+	samples := []brr.BankSample{}
+	offset := 0
+	failures := []string{}
+	for i := range m.Samples {
+		sample := &m.Samples[i]
+		built, err := buildSample(*sample, dir, outputDir)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", sample.Name, err))
+			fmt.Printf("FAIL %s: %v\n", sample.Name, err)
+			continue
+		}
 
-		codec.Encode()
+		fmt.Printf("OK   %s: %d bytes at bank offset %d\n", sample.Name, len(built.BrrData),
+			offset)
+		samples = append(samples, built)
+		offset += len(built.BrrData)
+	}
 
-		if err := codec.WriteBrrFile(args.OutputFile); err != nil {
-			fmt.Printf("Error creating output file. %v\n", err)
+	if len(failures) > 0 {
+		fmt.Printf("Build failed, %d of %d samples failed:\n", len(failures), len(m.Samples))
+		for _, failure := range failures {
+			fmt.Printf("  %s\n", failure)
+		}
+		return 1
+	}
+
+	bank := []byte{}
+	if base >= 0 {
+		packed, err := brr.PackBank(samples, base)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
 			return 1
 		}
+		bank = packed.Data
+
+		if m.Symbols != "" {
+			if err := writeSymbols(packed, filepath.Join(outputDir, m.Symbols)); err != nil {
+				fmt.Printf("Error writing symbols. %v\n", err)
+				return 1
+			}
+		}
 	} else {
-		if err := codec.ReadBrrFile(args.InputFile); err != nil {
-			fmt.Printf("Error loading input file. %v\n", err)
+		for _, sample := range samples {
+			bank = append(bank, sample.BrrData...)
+		}
+	}
+
+	if m.Bank != "" {
+		if err := os.WriteFile(filepath.Join(outputDir, m.Bank), bank, 0644); err != nil {
+			fmt.Printf("Error writing bank. %v\n", err)
+			return 1
+		}
+		fmt.Printf("Bank: %d bytes\n", len(bank))
+	}
+
+	return 0
+}
+
+func writeSymbols(bank *brr.Bank, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return bank.WriteSymbols(f)
+}
+
+// Encodes one sample of a manifest.
+func buildSample(sample manifestSample, dir string, outputDir string) (brr.BankSample,
+	error) {
+	built := brr.BankSample{Name: sample.Name}
+
+	args, err := sample.programArgs(dir, outputDir)
+	if err != nil {
+		return built, err
+	}
+
+	codec, err := createCodec(args)
+	if err != nil {
+		return built, err
+	}
+
+	if err := encodeInput(args, codec, args.InputFile, io.Discard); err != nil {
+		return built, err
+	}
+
+	if sample.MaxBytes > 0 && len(codec.BrrData) > sample.MaxBytes {
+		return built, fmt.Errorf("%d bytes is over the limit of %d", len(codec.BrrData),
+			sample.MaxBytes)
+	}
+
+	if err := codec.WriteBrrFile(args.OutputFile); err != nil {
+		return built, fmt.Errorf("creating output file: %w", err)
+	}
+
+	built.BrrData = codec.BrrData
+	built.LoopOffset = codec.LoopOffset
+	return built, nil
+}
+
+// Extracts the samples of an SPC file to BRR and WAV files.
+func runExtractSpc(cliArgs []string) returnCode {
+	if len(cliArgs) < 1 || len(cliArgs) > 2 {
+		fmt.Println("Error: extract-spc needs an SPC file.")
+		printUsage(true)
+		return 1
+	}
+
+	input := cliArgs[0]
+	outputDir := strings.TrimSuffix(input, filepath.Ext(input))
+	if len(cliArgs) > 1 {
+		outputDir = cliArgs[1]
+	}
+
+	spc, err := brr.ReadSpcFile(input)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	samples := spc.Samples()
+	fmt.Printf("%d samples in the directory at $%04X.\n", len(samples), spc.Dir())
+
+	for _, sample := range samples {
+		name := filepath.Join(outputDir, fmt.Sprintf("%02X", sample.Index))
+
+		codec := brr.NewCodec()
+		codec.SetBrrFormat("amk")
+		codec.BrrData = sample.BrrData
+		codec.LoopOffset = sample.LoopOffset
+		if err := codec.WriteBrrFile(name + ".brr"); err != nil {
+			fmt.Printf("Error writing BRR file. %v\n", err)
 			return 1
 		}
 
 		codec.Decode()
+		if err := codec.WriteWavFile(name + ".wav"); err != nil {
+			fmt.Printf("Error writing WAV file. %v\n", err)
+			return 1
+		}
+
+		loop := "no loop"
+		if sample.LoopOffset >= 0 {
+			loop = fmt.Sprintf("loop at %d", sample.LoopOffset)
+		}
+		fmt.Printf("  $%02X: $%04X, %d bytes, %s\n", sample.Index, sample.Start,
+			len(sample.BrrData), loop)
+	}
+
+	return 0
+}
+
+// Scans a binary file for BRR samples.
+func runScan(cliArgs []string) returnCode {
+	flagSet := flag.NewFlagSet("scan", flag.ContinueOnError)
+	minBlocks := flagSet.Int("min-blocks", 0, "Set the shortest sample to report")
+	minScore := flagSet.Float64("min-score", 0.5, "Set the lowest score to report")
+	dumpDir := flagSet.String("dump", "", "Write the samples found to a directory")
+	if err := flagSet.Parse(cliArgs); err != nil {
+		return 1
+	}
+
+	if flagSet.NArg() != 1 {
+		fmt.Println("Error: scan needs an input file.")
+		printUsage(true)
+		return 1
+	}
+
+	data, err := os.ReadFile(flagSet.Arg(0))
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	if *dumpDir != "" {
+		if err := os.MkdirAll(*dumpDir, 0755); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return 1
+		}
+	}
+
+	hits := brr.ScanBrr(data, *minBlocks, *minScore)
+	fmt.Printf("%d possible samples found.\n", len(hits))
+
+	for _, hit := range hits {
+		looped := ""
+		if hit.Looped {
+			looped = ", looped"
+		}
+		fmt.Printf("  $%06X: %d bytes%s, score %.3f\n", hit.Offset, hit.Size, looped,
+			hit.Score)
+
+		if *dumpDir == "" {
+			continue
+		}
+
+		name := filepath.Join(*dumpDir, fmt.Sprintf("%06X", hit.Offset))
+		codec := brr.NewCodec()
+		codec.BrrData = data[hit.Offset : hit.Offset+hit.Size]
+		if err := codec.WriteBrrFile(name + ".brr"); err != nil {
+			fmt.Printf("Error writing BRR file. %v\n", err)
+			return 1
+		}
 
-		if err := codec.WriteWavFile(args.OutputFile); err != nil {
-			fmt.Printf("Error writing output. %v\n", err)
+		codec.Decode()
+		if err := codec.WriteWavFile(name + ".wav"); err != nil {
+			fmt.Printf("Error writing WAV file. %v\n", err)
 			return 1
 		}
 	}
@@ -244,6 +1506,118 @@ func run(cliArgs []string) returnCode {
 	return 0
 }
 
+// Lists the blocks of a BRR file.
+func runInspect(cliArgs []string) returnCode {
+	flagSet := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	format := flagSet.String("format", "auto", "Set the BRR file format")
+	if err := flagSet.Parse(cliArgs); err != nil {
+		return 1
+	}
+
+	if flagSet.NArg() != 1 {
+		fmt.Println("Error: inspect needs a BRR file.")
+		printUsage(true)
+		return 1
+	}
+
+	codec := brr.NewCodec()
+	if err := codec.SetBrrFormat(*format); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if err := codec.ReadBrrFile(flagSet.Arg(0)); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	blocks, err := brr.InspectBrr(codec.BrrData)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Println("Block  Offset  Range  Filter  Flags   Peak")
+	warnings := 0
+	for i, block := range blocks {
+		flags := []byte("--")
+		if block.End {
+			flags[0] = 'E'
+		}
+		if block.Loop {
+			flags[1] = 'L'
+		}
+		loop := ""
+		if block.Offset == codec.LoopOffset {
+			loop = "  <- loop"
+		}
+		fmt.Printf("%5d  $%04X   %5d  %6d  %5s  %5d%s\n", i, block.Offset, block.Range,
+			block.Filter, flags, block.Peak, loop)
+
+		samples := []string{}
+		for _, s := range block.Samples {
+			samples = append(samples, strconv.Itoa(int(s)))
+		}
+		fmt.Printf("       %s\n", strings.Join(samples, " "))
+
+		for _, warning := range block.Warnings {
+			fmt.Printf("       ! %s\n", warning)
+		}
+		warnings += len(block.Warnings)
+	}
+
+	fmt.Printf("%d blocks, %d warnings.\n", len(blocks), warnings)
+	return 0
+}
+
+// Checks a BRR file for problems.
+func runValidate(cliArgs []string) returnCode {
+	flagSet := flag.NewFlagSet("validate", flag.ContinueOnError)
+	format := flagSet.String("format", "auto", "Set the BRR file format")
+	loopOffset := flagSet.Int("loop-offset", -1, "Set the loop offset")
+	if err := flagSet.Parse(cliArgs); err != nil {
+		return 1
+	}
+
+	if flagSet.NArg() != 1 {
+		fmt.Println("Error: validate needs a BRR file.")
+		printUsage(true)
+		return 1
+	}
+
+	codec := brr.NewCodec()
+	if err := codec.SetBrrFormat(*format); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if err := codec.ReadBrrFile(flagSet.Arg(0)); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+	if *loopOffset >= 0 {
+		codec.LoopOffset = *loopOffset
+	}
+
+	diagnostics, err := brr.ValidateBrr(codec.BrrData, codec.LoopOffset)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return 1
+	}
+
+	errorCount := 0
+	for _, d := range diagnostics {
+		fmt.Println(d)
+		if d.Severity == brr.SeverityError {
+			errorCount++
+		}
+	}
+
+	fmt.Printf("%d errors, %d warnings.\n", errorCount, len(diagnostics)-errorCount)
+	if errorCount > 0 {
+		return 1
+	}
+	return 0
+}
+
 func main() {
 	os.Exit(run(os.Args[1:]))
 }
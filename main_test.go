@@ -7,12 +7,16 @@ package main
 import (
 	"bytes"
 	"io"
+	"math"
 	"math/rand"
 	"os"
 	"os/exec"
 	"testing"
 
+	"github.com/go-audio/audio"
+	"github.com/go-audio/wav"
 	"github.com/stretchr/testify/assert"
+	"go.mukunda.com/snesbrr/v2/brr"
 )
 
 type runResult struct {
@@ -155,3 +159,446 @@ func TestInvalidCodecOption(t *testing.T) {
 		assert.NotZero(t, r.ret)
 	}
 }
+
+func TestStats(t *testing.T) {
+	defer os.Remove(".testfile_stats.brr")
+	defer os.Remove(".testfile_stats.wav")
+
+	createTestBrr(".testfile_stats.brr")
+	assert.Zero(t, runArgs("--decode", ".testfile_stats.brr", ".testfile_stats.wav").ret)
+
+	r := runArgs("--encode", "--stats", ".testfile_stats.wav", ".testfile_stats.brr")
+	assert.Zero(t, r.ret)
+	assert.Contains(t, r.output, "SNR:")
+	assert.Contains(t, r.output, "Blocks:            10")
+}
+
+func TestDspDecode(t *testing.T) {
+	defer os.Remove(".testfile_dsp.brr")
+	defer os.Remove(".testfile_dsp.wav")
+
+	createTestBrr(".testfile_dsp.brr")
+	r := runArgs("--decode", "--dsp", "--pitch", "0x800", ".testfile_dsp.brr", ".testfile_dsp.wav")
+	assert.Zero(t, r.ret)
+
+	os.Remove(".testfile_dsp.wav")
+	r = runArgs("--decode", "--dsp", "--pitch", "0x4000", ".testfile_dsp.brr", ".testfile_dsp.wav")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "invalid voice settings")
+}
+
+func TestDspEnvelope(t *testing.T) {
+	defer os.Remove(".testfile_dsp.brr")
+	defer os.Remove(".testfile_dsp.wav")
+
+	createTestBrr(".testfile_dsp.brr")
+	r := runArgs("--decode", "--adsr", "10,7,3,20", "--key-off", "0.001", ".testfile_dsp.brr",
+		".testfile_dsp.wav")
+	assert.Zero(t, r.ret)
+
+	os.Remove(".testfile_dsp.wav")
+	r = runArgs("--decode", "--adsr", "10,7,3", ".testfile_dsp.brr", ".testfile_dsp.wav")
+	assert.Equal(t, 1, r.ret)
+
+	r = runArgs("--decode", "--gain", "0x1FF", ".testfile_dsp.brr", ".testfile_dsp.wav")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "invalid voice settings")
+}
+
+func TestLoopPlayback(t *testing.T) {
+	defer os.Remove(".testfile_play.brr")
+	defer os.Remove(".testfile_play.wav")
+
+	createTestBrr(".testfile_play.brr")
+	r := runArgs("--decode", "--play-seconds", "0.25", "--loop-offset", "9", ".testfile_play.brr",
+		".testfile_play.wav")
+	assert.Zero(t, r.ret)
+
+	codec := brr.NewCodec()
+	assert.NoError(t, codec.ReadWavFile(".testfile_play.wav"))
+	assert.Len(t, codec.PcmData, 8000)
+
+	os.Remove(".testfile_play.wav")
+	r = runArgs("--decode", "--loop-offset", "10", ".testfile_play.brr", ".testfile_play.wav")
+	assert.Equal(t, 1, r.ret)
+}
+
+func TestLoopAuto(t *testing.T) {
+	defer os.Remove(".testfile_loop.brr")
+	defer os.Remove(".testfile_loop.wav")
+
+	createTestBrr(".testfile_loop.brr")
+	assert.Zero(t, runArgs("--decode", ".testfile_loop.brr", ".testfile_loop.wav").ret)
+
+	os.Remove(".testfile_loop.brr")
+	r := runArgs("--encode", "--loop", "auto", ".testfile_loop.wav", ".testfile_loop.brr")
+	assert.Zero(t, r.ret)
+	assert.Contains(t, r.output, "Loop start:")
+
+	r = runArgs("--encode", "--loop", "middle", ".testfile_loop.wav", ".testfile_loop.brr")
+	assert.Equal(t, 1, r.ret)
+}
+
+func TestLoopCrossfade(t *testing.T) {
+	defer os.Remove(".testfile_xfade.brr")
+	defer os.Remove(".testfile_xfade.wav")
+
+	createTestBrr(".testfile_xfade.brr")
+	assert.Zero(t, runArgs("--decode", ".testfile_xfade.brr", ".testfile_xfade.wav").ret)
+
+	os.Remove(".testfile_xfade.brr")
+	r := runArgs("--encode", "--loop", "64", "--loop-crossfade", "32", "--crossfade-curve",
+		"equal-power", ".testfile_xfade.wav", ".testfile_xfade.brr")
+	assert.Zero(t, r.ret)
+
+	r = runArgs("--encode", "--loop", "64", "--loop-crossfade", "32", "--crossfade-curve",
+		"cubic", ".testfile_xfade.wav", ".testfile_xfade.brr")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "invalid loop crossfade")
+}
+
+func TestLoopAlign(t *testing.T) {
+	defer os.Remove(".testfile_align.brr")
+	defer os.Remove(".testfile_align.wav")
+
+	createTestBrr(".testfile_align.brr")
+	assert.Zero(t, runArgs("--decode", ".testfile_align.brr", ".testfile_align.wav").ret)
+
+	r := runArgs("--encode", "--loop", "37", "--loop-align", "resample", ".testfile_align.wav",
+		".testfile_align.brr")
+	assert.Zero(t, r.ret)
+	assert.Contains(t, r.output, "cents")
+
+	// The loop of 123 samples is stretched to 128, which is far out of tune.
+	assert.Contains(t, r.output, "Warning: the loop is audibly out of tune")
+
+	r = runArgs("--encode", "--loop-align", "stretch", ".testfile_align.wav", ".testfile_align.brr")
+	assert.Equal(t, 1, r.ret)
+}
+
+func TestMix(t *testing.T) {
+	defer os.Remove(".testfile_mix.wav")
+	defer os.Remove(".testfile_mix_left.brr")
+	defer os.Remove(".testfile_mix_right.brr")
+	defer os.Remove(".testfile_mix_channel.brr")
+
+	// A sine on the left channel and silence on the right.
+	pcm := []int{}
+	for i := 0; i < 1024; i++ {
+		pcm = append(pcm, int(8000*math.Sin(float64(i)/10)), 0)
+	}
+	f, err := os.Create(".testfile_mix.wav")
+	assert.NoError(t, err)
+	encoder := wav.NewEncoder(f, 32000, 16, 2, 1)
+	assert.NoError(t, encoder.Write(&audio.IntBuffer{
+		Data:           pcm,
+		Format:         &audio.Format{NumChannels: 2, SampleRate: 32000},
+		SourceBitDepth: 16,
+	}))
+	encoder.Close()
+	f.Close()
+
+	assert.Zero(t, runArgs("--encode", "--mix", "left", ".testfile_mix.wav",
+		".testfile_mix_left.brr").ret)
+	assert.Zero(t, runArgs("--encode", "--mix", "right", ".testfile_mix.wav",
+		".testfile_mix_right.brr").ret)
+	assert.Zero(t, runArgs("--encode", "--channel", "1", ".testfile_mix.wav",
+		".testfile_mix_channel.brr").ret)
+
+	left, _ := os.ReadFile(".testfile_mix_left.brr")
+	right, _ := os.ReadFile(".testfile_mix_right.brr")
+	channel, _ := os.ReadFile(".testfile_mix_channel.brr")
+	assert.NotEqual(t, left, right)
+	assert.Equal(t, right, channel, "--channel 1 should match --mix right")
+
+	r := runArgs("--encode", "--mix", "bad", ".testfile_mix.wav", ".testfile_mix_left.brr")
+	assert.Equal(t, 1, r.ret)
+
+	r = runArgs("--encode", "--mix", "left", "--channel", "0", ".testfile_mix.wav",
+		".testfile_mix_left.brr")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "can't be used together")
+
+	r = runArgs("--encode", "--channel", "-1", ".testfile_mix.wav", ".testfile_mix_left.brr")
+	assert.Contains(t, r.output, "--channel must be 0 or more")
+}
+
+func TestMaxBytes(t *testing.T) {
+	defer os.Remove(".testfile_budget.brr")
+	defer os.Remove(".testfile_budget.wav")
+
+	createTestBrr(".testfile_budget.brr")
+	assert.Zero(t, runArgs("--decode", ".testfile_budget.brr", ".testfile_budget.wav").ret)
+
+	r := runArgs("--encode", "--max-bytes", "45", ".testfile_budget.wav", ".testfile_budget.brr")
+	assert.Zero(t, r.ret)
+	assert.Contains(t, r.output, "Budget: 45 bytes at 16")
+
+	info, err := os.Stat(".testfile_budget.brr")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(45), info.Size())
+
+	r = runArgs("--encode", "--loop", "0", "--max-bytes", "9", "--min-rate", "16000",
+		".testfile_budget.wav", ".testfile_budget.brr")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "byte budget too small")
+}
+
+func TestBatch(t *testing.T) {
+	defer os.RemoveAll(".testdir_batch")
+	defer os.RemoveAll(".testdir_batch_out")
+
+	assert.NoError(t, os.MkdirAll(".testdir_batch/sub", 0755))
+	createTestBrr(".testdir_batch/a.brr")
+	createTestBrr(".testdir_batch/sub/b.brr")
+	os.WriteFile(".testdir_batch/sub/broken.brr", []byte{}, 0644)
+
+	r := runArgs("--decode", "--jobs", "2", ".testdir_batch", ".testdir_batch_out")
+	assert.Zero(t, r.ret)
+	assert.FileExists(t, ".testdir_batch_out/a.wav")
+	assert.FileExists(t, ".testdir_batch_out/sub/b.wav")
+	assert.Contains(t, r.output, "3 files, 3 succeeded, 0 failed.")
+
+	// The wav files are encoded next to the originals, and a failure doesn't stop the rest.
+	os.WriteFile(".testdir_batch_out/sub/broken.wav", []byte("not a wav"), 0644)
+	r = runArgs("--encode", ".testdir_batch_out/*/*.wav")
+	assert.Equal(t, 1, r.ret)
+	assert.FileExists(t, ".testdir_batch_out/sub/b.wav.brr")
+	assert.Contains(t, r.output, "2 files, 1 succeeded, 1 failed.")
+	assert.Contains(t, r.output, "FAIL .testdir_batch_out/sub/broken.wav")
+
+	// A file with wildcard characters in its name isn't taken as a pattern.
+	createTestBrr(".testdir_batch/[take 1].brr")
+	r = runArgs("--decode", ".testdir_batch/[take 1].brr", ".testdir_batch/take.wav")
+	assert.Zero(t, r.ret)
+	assert.NotContains(t, r.output, "files,")
+	assert.FileExists(t, ".testdir_batch/take.wav")
+}
+
+func TestBuild(t *testing.T) {
+	defer os.RemoveAll(".testdir_build")
+
+	assert.NoError(t, os.MkdirAll(".testdir_build", 0755))
+	createTestBrr(".testdir_build/a.brr")
+	assert.Zero(t, runArgs("--decode", ".testdir_build/a.brr", ".testdir_build/a.wav").ret)
+
+	manifest := `{
+		"output_dir": "out",
+		"bank": "bank.bin",
+		"samples": [
+			{ "name": "first", "input": "a.wav", "loop": 32 },
+			{ "input": "a.wav", "rate": 16000, "options": { "lookahead": "1" } }
+		]
+	}`
+	os.WriteFile(".testdir_build/manifest.json", []byte(manifest), 0644)
+
+	r := runArgs("build", ".testdir_build/manifest.json")
+	assert.Zero(t, r.ret)
+	assert.FileExists(t, ".testdir_build/out/first.brr")
+	assert.FileExists(t, ".testdir_build/out/a.brr")
+
+	bank, err := os.ReadFile(".testdir_build/out/bank.bin")
+	assert.NoError(t, err)
+	assert.Len(t, bank, 10*9+5*9)
+
+	// Samples over their size limit fail the build, and aren't written.
+	manifest = `{ "samples": [ { "name": "big", "input": "a.wav", "max_bytes": 80 } ] }`
+	os.WriteFile(".testdir_build/manifest.json", []byte(manifest), 0644)
+	r = runArgs("build", ".testdir_build/manifest.json")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "90 bytes is over the limit of 80")
+	assert.NoFileExists(t, ".testdir_build/big.brr")
+
+	// Samples can't share a name or an output file.
+	manifest = `{ "samples": [ { "input": "a.wav" }, { "input": "out/a.wav" } ] }`
+	os.WriteFile(".testdir_build/manifest.json", []byte(manifest), 0644)
+	r = runArgs("build", ".testdir_build/manifest.json")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, `more than one sample is named "a"`)
+
+	manifest = `{ "samples": [
+		{ "name": "x", "input": "a.wav", "output": "same.brr" },
+		{ "name": "y", "input": "a.wav", "output": "./same.brr" }
+	] }`
+	os.WriteFile(".testdir_build/manifest.json", []byte(manifest), 0644)
+	r = runArgs("build", ".testdir_build/manifest.json")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "more than one sample is written to")
+
+	// Loop points must be whole numbers.
+	manifest = `{ "samples": [ { "input": "a.wav", "loop": 32.5 } ] }`
+	os.WriteFile(".testdir_build/manifest.json", []byte(manifest), 0644)
+	r = runArgs("build", ".testdir_build/manifest.json")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "loop that isn't a whole number")
+
+	// Settings are checked like the matching arguments.
+	manifest = `{ "samples": [ { "input": "a.wav", "channel": -1 } ] }`
+	os.WriteFile(".testdir_build/manifest.json", []byte(manifest), 0644)
+	r = runArgs("build", ".testdir_build/manifest.json")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "has a negative channel")
+	r = runArgs("--encode", "--channel", "-1", ".testdir_build/a.wav", ".testdir_build/a.brr")
+	assert.Equal(t, 1, r.ret)
+
+	manifest = `{ "samples": [ { "input": "a.wav", "lop": 10 } ] }`
+	os.WriteFile(".testdir_build/manifest.json", []byte(manifest), 0644)
+	r = runArgs("build", ".testdir_build/manifest.json")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "unknown field")
+}
+
+func TestBuildBank(t *testing.T) {
+	defer os.RemoveAll(".testdir_bank")
+
+	assert.NoError(t, os.MkdirAll(".testdir_bank", 0755))
+	createTestBrr(".testdir_bank/a.brr")
+	assert.Zero(t, runArgs("--decode", ".testdir_bank/a.brr", ".testdir_bank/a.wav").ret)
+
+	manifest := `{
+		"bank": "bank.bin",
+		"base": "0x400",
+		"symbols": "bank.inc",
+		"samples": [
+			{ "name": "first", "input": "a.wav", "loop": 32 },
+			{ "name": "second", "input": "a.wav" }
+		]
+	}`
+	os.WriteFile(".testdir_bank/manifest.json", []byte(manifest), 0644)
+
+	r := runArgs("build", ".testdir_bank/manifest.json")
+	assert.Zero(t, r.ret)
+
+	bank, err := os.ReadFile(".testdir_bank/bank.bin")
+	assert.NoError(t, err)
+	assert.Len(t, bank, 8+10*9+10*9)
+	assert.Equal(t, []byte{0x08, 0x04, 0x1A, 0x04, 0x62, 0x04, 0x62, 0x04}, bank[:8])
+
+	symbols, err := os.ReadFile(".testdir_bank/bank.inc")
+	assert.NoError(t, err)
+	assert.Contains(t, string(symbols), "second_START = $0462")
+}
+
+func TestSourceFormat(t *testing.T) {
+	defer os.Remove(".testfile_source.brr")
+	defer os.Remove(".testfile_source.wav")
+	defer os.Remove(".testfile_source.wav.asm")
+
+	createTestBrr(".testfile_source.brr")
+	assert.Zero(t, runArgs("--decode", ".testfile_source.brr", ".testfile_source.wav").ret)
+
+	r := runArgs("--encode", "--format", "ca65", "--label", "lead", ".testfile_source.wav")
+	assert.Zero(t, r.ret)
+	source, err := os.ReadFile(".testfile_source.wav.asm")
+	assert.NoError(t, err)
+	assert.Contains(t, string(source), "lead_LENGTH = 90\n")
+
+	r = runArgs("--decode", "--format", "ca65", ".testfile_source.wav.asm", ".testfile_source.wav")
+	assert.Equal(t, 1, r.ret)
+
+	defer os.Remove(".testfile_source.go")
+	r = runArgs("--encode", "--format", "go", "--package", "sounds", ".testfile_source.wav",
+		".testfile_source.go")
+	assert.Zero(t, r.ret)
+	source, err = os.ReadFile(".testfile_source.go")
+	assert.NoError(t, err)
+	assert.Contains(t, string(source), "package sounds\n")
+	assert.Contains(t, string(source), "const Brr_testfile_sourceLength = 90\n")
+
+	// Every file of a batch would get the same label.
+	r = runArgs("--encode", "--format", "ca65", "--label", "lead", ".testfile_source*.wav")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "--label can't be used in batch mode")
+}
+
+func TestExtractSpc(t *testing.T) {
+	defer os.Remove(".testfile_extract.spc")
+	defer os.RemoveAll(".testfile_extract")
+
+	spc := make([]byte, 0x10200)
+	copy(spc, "SNES-SPC700 Sound File Data v0.30")
+	spc[0x10100+0x5D] = 0x02
+	copy(spc[0x100+0x200:], []byte{0x00, 0x03, 0x09, 0x03})
+	spc[0x100+0x300] = 0xB0
+	spc[0x100+0x309] = 0xB7
+	os.WriteFile(".testfile_extract.spc", spc, 0644)
+
+	r := runArgs("extract-spc", ".testfile_extract.spc")
+	assert.Zero(t, r.ret)
+	assert.Contains(t, r.output, "$00: $0300, 18 bytes, loop at 9")
+
+	brrFile, err := os.ReadFile(".testfile_extract/00.brr")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{9, 0, 0xB0}, brrFile[:3])
+	assert.FileExists(t, ".testfile_extract/00.wav")
+
+	r = runArgs("extract-spc", ".testfile_extract/00.brr")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "invalid spc file")
+}
+
+func TestScan(t *testing.T) {
+	defer os.Remove(".testfile_scan.bin")
+	defer os.RemoveAll(".testfile_scan")
+
+	// A flat waveform between bytes that have invalid ranges.
+	sample := []byte{}
+	for i := 0; i < 10; i++ {
+		sample = append(sample, 0x80)
+		sample = append(sample, bytes.Repeat([]byte{0x22}, 8)...)
+	}
+	sample[81] = 0x81
+	data := append(bytes.Repeat([]byte{0xFF}, 100), sample...)
+	data = append(data, bytes.Repeat([]byte{0xFF}, 100)...)
+	os.WriteFile(".testfile_scan.bin", data, 0644)
+
+	r := runArgs("scan", "--min-blocks", "4", "--dump", ".testfile_scan", ".testfile_scan.bin")
+	assert.Zero(t, r.ret)
+	assert.Contains(t, r.output, "1 possible samples found.")
+	assert.Contains(t, r.output, "$000064: 90 bytes")
+
+	dumped, err := os.ReadFile(".testfile_scan/000064.brr")
+	assert.NoError(t, err)
+	assert.Equal(t, sample, dumped)
+	assert.FileExists(t, ".testfile_scan/000064.wav")
+}
+
+func TestInspect(t *testing.T) {
+	defer os.Remove(".testfile_inspect.brr")
+
+	brrData := append([]byte{9, 0}, bytes.Repeat([]byte{0xC0, 0x77, 0x77, 0x77, 0x77, 0x77,
+		0x77, 0x77, 0x77}, 2)...)
+	brrData[11] = 0xF3
+	os.WriteFile(".testfile_inspect.brr", brrData, 0644)
+
+	r := runArgs("inspect", ".testfile_inspect.brr")
+	assert.Zero(t, r.ret)
+	assert.Contains(t, r.output, "    0  $0000      12       0     --  28672\n")
+	assert.Contains(t, r.output, "    1  $0009      15       0     EL")
+	assert.Contains(t, r.output, "<- loop")
+	assert.Contains(t, r.output, "! range 15 is invalid")
+	assert.Contains(t, r.output, "2 blocks, 1 warnings.")
+
+	r = runArgs("inspect")
+	assert.Equal(t, 1, r.ret)
+}
+
+func TestValidate(t *testing.T) {
+	defer os.Remove(".testfile_validate.brr")
+
+	brrData := []byte{0xC0, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88, 0x88}
+	os.WriteFile(".testfile_validate.brr", brrData, 0644)
+
+	r := runArgs("validate", ".testfile_validate.brr")
+	assert.Equal(t, 1, r.ret)
+	assert.Contains(t, r.output, "error: block 0 ($0000): the last block has no END flag "+
+		"[missing-end]")
+	assert.Contains(t, r.output, "warning: block 0 ($0000) sample 2: samples near full "+
+		"scale can overflow the gaussian interpolation [gaussian-overflow]")
+
+	brrData[0] = 0xB3
+	os.WriteFile(".testfile_validate.brr", brrData, 0644)
+	r = runArgs("validate", "--loop-offset", "0", ".testfile_validate.brr")
+	assert.Zero(t, r.ret)
+	assert.Contains(t, r.output, "0 errors, 0 warnings.")
+}